@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
+)
+
+// authMethods parses -auth into whether Basic, bearer and client certificate authentication are enabled.
+func authMethods(methods string) (basic bool, bearer bool, certificate bool, err error) {
+	for _, method := range strings.Split(methods, ",") {
+		switch strings.TrimSpace(method) {
+		case "basic":
+			basic = true
+		case "bearer":
+			bearer = true
+		case "certificate":
+			certificate = true
+		default:
+			return false, false, false, errors.New("-auth must be a comma separated list of basic, bearer and certificate")
+		}
+	}
+	return basic, bearer, certificate, nil
+}
+
+// newTokenVerifier verifies bearer tokens of issuer against the JWKS at jwks, an http(s)
+// URL fetched and cached like any JWKS, or a file read once.
+func newTokenVerifier(issuer, audience, jwks, scopes string) (*auth.TokenVerifier, error) {
+	if issuer == "" || jwks == "" {
+		return nil, errors.New("bearer authentication needs -oauth-issuer and -oauth-jwks")
+	}
+
+	var keys auth.KeySource
+	if strings.HasPrefix(jwks, "https://") || strings.HasPrefix(jwks, "http://") {
+		keys = crypto.NewRemoteKeySet(jwks, &http.Client{Timeout: 10 * time.Second})
+	} else {
+		keySet, err := crypto.LoadJWKS(jwks)
+		if err != nil {
+			return nil, err
+		}
+		keys = auth.StaticKeys(keySet.VerificationKey)
+	}
+
+	var scopeRoles map[string]auth.Role
+	if scopes != "" {
+		var err error
+		scopeRoles, err = auth.ParseScopes(scopes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return auth.NewTokenVerifier(issuer, audience, keys, scopeRoles), nil
+}
@@ -1,64 +1,222 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/x509"
-	"encoding/pem"
-	"errors"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/api"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
 	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
 	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/database"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/pkg/models"
 )
 
-func ParseECDSAPublicKeyFromPEM(pemEncodedPubKey string) (*ecdsa.PublicKey, error) {
-	block, _ := pem.Decode([]byte(pemEncodedPubKey))
-	if block == nil || block.Type != "PUBLIC KEY" {
-		return nil, errors.New("failed to decode PEM block containing public key")
+func main() {
+	keyFile := flag.String("key", "ecdsa_key.pem", "PEM file with the ECDSA P-256 key used to sign status tokens")
+	keyID := flag.String("kid", "", "key ID stamped on issued tokens (defaults to the JWK thumbprint)")
+	signerAddr := flag.String("signer", "", "address of an external signer (unix:///path) used instead of -key")
+	retiredKeys := flag.String("retired-keys", "", "comma separated PEM files of previous signing keys to keep publishing for -token-lifetime")
+	keyDir := flag.String("key-dir", "", "directory keeping the keys generated by key rotation; on startup its newest key replaces -key")
+	rotateEvery := flag.Duration("rotate-every", 0, "rotate to a freshly generated signing key, kept in -key-dir, at this interval (0 disables)")
+	baseURL := flag.String("base-url", "http://localhost:8000", "externally visible URL of the server, used in token claims")
+	tokenLifetime := flag.Duration("token-lifetime", 24*time.Hour, "validity of issued status tokens")
+	tokenTTL := flag.Duration("token-ttl", time.Hour, "how long verifiers may cache a status list token")
+	storage := flag.String("storage", "postgres", "where status lists are stored: postgres, sqlite or memory (for development)")
+	sqliteFile := flag.String("sqlite-file", "status.db", "database file for -storage sqlite, created if missing")
+	listCapacity := flag.Int("list-capacity", status.DefaultSize, "entries per status list, a multiple of 8; full lists are sealed and new references go to a new list")
+	autoMigrate := flag.Bool("migrate", true, "apply pending database migrations at startup")
+	usersFile := flag.String("users", "users.json", "JSON file with the API users and their bcrypt password hashes, managed with the users subcommand")
+	authFlag := flag.String("auth", "basic", "how management requests authenticate, a comma separated list of basic, bearer (OAuth2 access tokens) and certificate (TLS client certificates of users)")
+	oauthIssuer := flag.String("oauth-issuer", "", "required \"iss\" of bearer tokens")
+	oauthAudience := flag.String("oauth-audience", "", "required \"aud\" of bearer tokens, if any")
+	oauthJWKS := flag.String("oauth-jwks", "", "URL or file of the JWKS bearer tokens are signed with")
+	oauthScopes := flag.String("oauth-scopes", "", "comma separated scope=role mappings for bearer tokens (default status:read=reader,status:issue=issuer,status:revoke=revoker,status:admin=admin)")
+	addr := flag.String("addr", ":8000", "address to listen on")
+	tlsCert := flag.String("tls-cert", "", "PEM file with the server's TLS certificate chain; serves HTTPS instead of HTTP")
+	tlsKey := flag.String("tls-key", "", "PEM file with the private key of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM bundle of the CAs client certificates are verified against")
+	tlsClientAuth := flag.String("tls-client-auth", "", "client certificates: none, request (verify if sent) or require (default request with -tls-client-ca, otherwise none)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *listCapacity <= 0 || *listCapacity%8 != 0 {
+		log.Fatalf("-list-capacity must be a positive multiple of 8")
+	}
+
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrate(*storage, *sqliteFile, flag.Args()[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "users" {
+		if err := runUsers(*usersFile, flag.Args()[1:]); err != nil {
+			log.Fatalf("Failed to update users: %v", err)
+		}
+		return
 	}
 
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	basicAuth, bearerAuth, certificateAuth, err := authMethods(*authFlag)
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
 	}
 
-	switch pub := pub.(type) {
-	case *ecdsa.PublicKey:
-		return pub, nil
-	default:
-		return nil, errors.New("not ECDSA public key")
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		clientAuth := auth.ClientAuth(*tlsClientAuth)
+		if clientAuth == "" {
+			clientAuth = auth.ClientAuthNone
+			if *tlsClientCA != "" {
+				clientAuth = auth.ClientAuthRequest
+			}
+		}
+		tlsConfig, err = auth.ServerTLSConfig(*tlsCert, *tlsKey, *tlsClientCA, clientAuth)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+	}
+	if certificateAuth && (tlsConfig == nil || tlsConfig.ClientCAs == nil) {
+		log.Fatalf("-auth certificate needs -tls-cert, -tls-key and -tls-client-ca")
 	}
-}
 
-func main() {
-	// PostgreSQL podatki
-	dbUser := "user"
-	dbPassword := "pass"
-	dbName := "imebaze"
-	dbHost := "localhost"
-	dbPort := "5432"
+	var users *auth.UserStore
+	if basicAuth || certificateAuth {
+		users, err = auth.OpenUserStore(*usersFile)
+		if err != nil {
+			log.Fatalf("Failed to load users: %v", err)
+		}
+		if len(users.Users()) == 0 {
+			log.Printf("No users in %s, add one with: %s users add NAME (or add-cert NAME)", *usersFile, os.Args[0])
+		}
+		for _, user := range users.Users() {
+			if len(user.Roles) == 0 {
+				log.Printf("User %s has no roles and cannot access the API, grant them with: %s users roles %s ROLE", user.Name, os.Args[0], user.Name)
+			}
+		}
+	}
 
-	// PostgreSQL connection string
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUser, dbPassword, dbHost, dbPort, dbName)
+	var tokens *auth.TokenVerifier
+	if bearerAuth {
+		tokens, err = newTokenVerifier(*oauthIssuer, *oauthAudience, *oauthJWKS, *oauthScopes)
+		if err != nil {
+			log.Fatalf("Failed to configure bearer authentication: %v", err)
+		}
+	}
+
+	// Ključ za podpisovanje
+	var signer crypto.Signer
+	if *signerAddr != "" {
+		signer, err = crypto.DialSigner(*signerAddr, 5*time.Second)
+	} else {
+		signer, err = crypto.NewFileSigner(*keyFile, *keyID)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load signing key: %v", err)
+	}
+
+	keys := crypto.NewKeyRegistry(signer, *tokenLifetime)
+
+	// Keys rotated in are kept in -key-dir, so a restart continues with them
+	var dir *crypto.KeyDir
+	if *keyDir != "" {
+		if *signerAddr != "" {
+			log.Fatalf("-key-dir generates keys in the server and cannot be combined with -signer")
+		}
+		dir, err = crypto.OpenKeyDir(*keyDir)
+		if err != nil {
+			log.Fatalf("Failed to open key directory: %v", err)
+		}
+		if err := dir.Restore(keys); err != nil {
+			log.Fatalf("Failed to restore keys from %s: %v", *keyDir, err)
+		}
+	}
+	if *retiredKeys != "" {
+		for _, filename := range strings.Split(*retiredKeys, ",") {
+			publicKey, err := crypto.LoadECDSAPublicKey(strings.TrimSpace(filename))
+			if err != nil {
+				log.Fatalf("Failed to load retired key %s: %v", filename, err)
+			}
+			if err := keys.AddRetired(crypto.KeyID(publicKey), publicKey, time.Now().Add(*tokenLifetime)); err != nil {
+				log.Fatalf("Failed to add retired key %s: %v", filename, err)
+			}
+		}
+	}
+
+	// Keys rotated in through the API are kept in -key-dir too; without it the route is disabled
+	var generateKey func() (crypto.Signer, error)
+	if dir != nil {
+		generateKey = dir.Generate
+	}
+	if *rotateEvery > 0 {
+		if dir == nil {
+			log.Fatalf("-rotate-every needs -key-dir to keep the generated keys across restarts")
+		}
+		stopRotation := keys.StartRotation(*rotateEvery, dir.Generate)
+		defer stopRotation()
+	}
 
-	// Inicializacija baze
-	if err := database.InitDB(connStr); err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	// Shramba
+	var repo models.StatusRepository
+	switch *storage {
+	case "postgres", "sqlite":
+		// Inicializacija baze
+		driver, dataSourceName := dataSource(*storage, *sqliteFile)
+		if err := database.InitDB(driver, dataSourceName); err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer database.CloseDB()
+
+		if *autoMigrate {
+			applied, err := database.MigrateUp(database.DB, driver)
+			if err != nil {
+				log.Fatalf("Failed to migrate database: %v", err)
+			}
+			if applied > 0 {
+				log.Printf("Applied %d database migrations", applied)
+			}
+		}
+		repo = models.NewSQLRepository(database.DB)
+	case "memory":
+		log.Println("Using in-memory storage, status lists are lost on shutdown")
+		repo = models.NewMemoryRepository()
+	default:
+		log.Fatalf("Unknown storage %q, use postgres, sqlite or memory", *storage)
+	}
+
+	var basicUsers, certificateUsers *auth.UserStore
+	if basicAuth {
+		basicUsers = users
+	}
+	if certificateAuth {
+		certificateUsers = users
 	}
-	defer database.CloseDB()
 
 	// Določi port
-	router := api.SetupRouter()
+	router := api.SetupRouter(api.Config{
+		Repo:          repo,
+		Keys:          keys,
+		BaseURL:       strings.TrimSuffix(*baseURL, "/"),
+		TokenLifetime: *tokenLifetime,
+		TokenTTL:      *tokenTTL,
+		ListCapacity:  *listCapacity,
+		Users:         basicUsers,
+		Tokens:        tokens,
+		Certificates:  certificateUsers,
+		GenerateKey:   generateKey,
+	})
 	server := &http.Server{
-		Addr:    ":8000",
-		Handler: router,
+		Addr:      *addr,
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
 
 	// Graceful shutdown
@@ -66,8 +224,15 @@ func main() {
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		log.Println("Starting server on :8000")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("Starting server on %s with TLS", *addr)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Starting server on %s", *addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe(): %v", err)
 		}
 	}()
@@ -79,47 +244,21 @@ func main() {
 		log.Fatalf("Server Close(): %v", err)
 	}
 	log.Println("Server gracefully stopped")
+}
 
-	// URL za: GetStatusFromJWS
-	url := "http://localhost:8000/api/status/testStatusId?index=1"
-	pemEncodedPublicKey := `
------BEGIN PUBLIC KEY-----
-//prilagodi ključ
------END PUBLIC KEY-----
-`
-
-	publicKey, err := ParseECDSAPublicKeyFromPEM(pemEncodedPublicKey)
-	if err != nil {
-		log.Fatalf("Error parsing public key: %v", err)
-	}
-
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Fatalf("Error creating request: %v", err)
-	}
-
-	req.SetBasicAuth("user", "pass") // replace with actual credentials
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatalf("Error making request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("Error getting status from JWS: received non-OK HTTP status: %s", resp.Status)
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Error reading response body: %v", err)
+// dataSource returns the database driver and data source name for a -storage backed by SQL.
+func dataSource(storage, sqliteFile string) (string, string) {
+	if storage == "sqlite" {
+		return "sqlite", sqliteFile
 	}
 
-	status, err := crypto.ParseJWSResponse(body, publicKey)
-	if err != nil {
-		log.Fatalf("Error getting status from JWS: %v", err)
-	}
+	// PostgreSQL podatki
+	dbUser := "user"
+	dbPassword := "pass"
+	dbName := "imebaze"
+	dbHost := "localhost"
+	dbPort := "5432"
 
-	fmt.Printf("Status: %v\n", status)
+	// PostgreSQL connection string
+	return "postgres", fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUser, dbPassword, dbHost, dbPort, dbName)
 }
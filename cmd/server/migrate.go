@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/database"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] migrate up|down [steps]|status\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] users add|add-cert|passwd|disable|enable NAME | users roles NAME ROLE,... | users lists NAME [ID,...] | users list\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runMigrate runs the migrate subcommand against the database selected by -storage.
+func runMigrate(storage, sqliteFile string, args []string) error {
+	if storage != "postgres" && storage != "sqlite" {
+		return fmt.Errorf("storage %s has no schema to migrate", storage)
+	}
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	driver, dataSourceName := dataSource(storage, sqliteFile)
+	if err := database.InitDB(driver, dataSourceName); err != nil {
+		return err
+	}
+	defer database.CloseDB()
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp(database.DB, driver)
+		fmt.Printf("Applied %d migrations\n", applied)
+		return err
+	case "down":
+		// Revert one migration at a time unless told otherwise, as down loses data
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		reverted, err := database.MigrateDown(database.DB, driver, steps)
+		fmt.Printf("Reverted %d migrations\n", reverted)
+		return err
+	case "status":
+		states, err := database.MigrationStatus(database.DB, driver)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, state := range states {
+			applied := "pending"
+			if !state.AppliedAt.IsZero() {
+				applied = state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%03d\t%s\t%s\n", state.Version, state.Name, applied)
+		}
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+}
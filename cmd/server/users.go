@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
+)
+
+// runUsers runs the users subcommand against the users file. A running server picks up
+// the changes without a restart.
+func runUsers(usersFile string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing users command, use add, add-cert, passwd, roles, lists, disable, enable or list")
+	}
+
+	store, err := auth.OpenUserStore(usersFile)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "list" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATUS\tROLES\tLISTS")
+		for _, user := range store.Users() {
+			state := "enabled"
+			if user.Disabled {
+				state = "disabled"
+			}
+			roles := make([]string, len(user.Roles))
+			for i, role := range user.Roles {
+				roles[i] = string(role)
+			}
+			lists := "all"
+			if len(user.Lists) > 0 {
+				lists = strings.Join(user.Lists, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", user.Name, state, strings.Join(roles, ","), lists)
+		}
+		return w.Flush()
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: users %s NAME", args[0])
+	}
+	name := args[1]
+
+	switch args[0] {
+	case "roles":
+		if len(args) != 3 {
+			return errors.New("usage: users roles NAME ROLE[,ROLE...]")
+		}
+	case "lists":
+		if len(args) > 3 {
+			return errors.New("usage: users lists NAME [ID[,ID...]]")
+		}
+	default:
+		if len(args) != 2 {
+			return fmt.Errorf("usage: users %s NAME", args[0])
+		}
+	}
+
+	switch args[0] {
+	case "add":
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return errors.New("empty password, use add-cert for users with client certificates only")
+		}
+		if err := store.Add(name, password); err != nil {
+			return err
+		}
+	case "add-cert":
+		// Without a password the user can only authenticate with a client certificate for its name
+		if err := store.Add(name, ""); err != nil {
+			return err
+		}
+	case "passwd":
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := store.SetPassword(name, password); err != nil {
+			return err
+		}
+	case "roles":
+		roles, err := auth.ParseRoles(args[2])
+		if err != nil {
+			return err
+		}
+		if err := store.SetRoles(name, roles); err != nil {
+			return err
+		}
+	case "lists":
+		// Without IDs the roles apply to every list again
+		var statusIds []string
+		if len(args) == 3 {
+			statusIds = strings.Split(args[2], ",")
+		}
+		if err := store.SetLists(name, statusIds); err != nil {
+			return err
+		}
+	case "disable", "enable":
+		if err := store.SetDisabled(name, args[0] == "disable"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown users command %q, use add, add-cert, passwd, roles, lists, disable, enable or list", args[0])
+	}
+
+	if err := store.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Updated user %s in %s\n", name, usersFile)
+	return nil
+}
+
+// readPassword reads the password from the first line of standard input, so it can be
+// piped in instead of appearing in the process list.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
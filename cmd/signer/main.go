@@ -0,0 +1,61 @@
+// Command signer keeps the status signing key outside the API process. The server
+// connects to it with -signer and only ever sees digests and signatures.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
+)
+
+func main() {
+	keyFile := flag.String("key", "ecdsa_key.pem", "PEM file with the ECDSA P-256 signing key")
+	keyID := flag.String("kid", "", "key ID reported to the server (defaults to the JWK thumbprint)")
+	listen := flag.String("listen", "unix:///tmp/ecdsa-signer.sock", "Unix socket to listen on (unix:///path)")
+	flag.Parse()
+
+	// The signer authenticates nobody, so it is only reachable through a socket file only its owner may open
+	if !strings.HasPrefix(*listen, "unix://") {
+		log.Fatalf("Unsupported listen address %q, use unix:///path", *listen)
+	}
+	address := strings.TrimPrefix(*listen, "unix://")
+
+	signer, err := crypto.NewFileSigner(*keyFile, *keyID)
+	if err != nil {
+		log.Fatalf("Failed to load signing key: %v", err)
+	}
+
+	if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to remove stale socket %s: %v", address, err)
+	}
+
+	// The socket is created without group and other permissions, so there is no window in which others may connect
+	restrictUmask()
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listen, err)
+	}
+	if err := os.Chmod(address, 0600); err != nil {
+		log.Fatalf("Failed to restrict socket %s: %v", address, err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		listener.Close()
+	}()
+
+	log.Printf("Signing with key %s on %s", signer.KeyID(), *listen)
+	if err := crypto.ServeSigner(listener, signer); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Fatalf("ServeSigner(): %v", err)
+	}
+	log.Println("Signer stopped")
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// restrictUmask makes files created from now on, such as the socket, accessible to their owner only.
+func restrictUmask() {
+	syscall.Umask(0077)
+}
@@ -0,0 +1,5 @@
+package main
+
+// restrictUmask does nothing on Windows, which has no umask; the socket is restricted by
+// the permissions of its directory.
+func restrictUmask() {}
@@ -0,0 +1,41 @@
+// Command statuscheck resolves a status reference against a status list endpoint,
+// verifying the token with the keys the issuer publishes in its JWKS.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/pkg/statusclient"
+)
+
+func main() {
+	uri := flag.String("uri", "http://localhost:8000/statuslists/1", "status list URI from the credential's status reference")
+	index := flag.Int("index", 0, "index from the credential's status reference")
+	timeout := flag.Duration("timeout", 10*time.Second, "HTTP timeout")
+	caFile := flag.String("ca", "", "PEM bundle of the CAs trusted for the server's certificate (default the system roots)")
+	certFile := flag.String("cert", "", "PEM file with a client certificate, for servers requiring one")
+	keyFile := flag.String("key", "", "PEM file with the private key of -cert")
+	flag.Parse()
+
+	tlsConfig, err := auth.ClientTLSConfig(*caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("Error configuring TLS: %v", err)
+	}
+	client := statusclient.New(&http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	})
+
+	status, err := client.Status(context.Background(), *uri, *index)
+	if err != nil {
+		log.Fatalf("Error getting status: %v", err)
+	}
+
+	fmt.Printf("Status: %v\n", status)
+}
@@ -1,20 +1,111 @@
 package api
 
 import (
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/gorilla/mux"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/pkg/models"
 )
 
-func SetupRouter() *mux.Router {
+// Config holds the dependencies shared by the API handlers.
+type Config struct {
+	// Repo stores the status lists.
+	Repo models.StatusRepository
+	// Keys holds the active signing key, which signs every token returned by the
+	// API and supplies its "kid", and the retired keys verifiers may still need.
+	Keys *crypto.KeyRegistry
+	// BaseURL is the externally visible URL of the server, e.g. https://status.example.com.
+	BaseURL string
+	// TokenLifetime is how long an issued token is valid ("exp" - "iat").
+	TokenLifetime time.Duration
+	// TokenTTL is how long verifiers may cache a status list token ("ttl").
+	TokenTTL time.Duration
+	// ListCapacity is the number of entries of a new list, a multiple of 8 so lists of any
+	// bits fill whole bytes. Once they are all allocated the list is sealed and new
+	// references go to another list.
+	ListCapacity int
+	// Users are the credentials accepted with Basic authentication. Nil disables it.
+	Users *auth.UserStore
+	// Tokens verifies OAuth2 bearer tokens. Nil disables bearer authentication.
+	Tokens *auth.TokenVerifier
+	// Certificates are the users verified TLS client certificates authenticate as, by the
+	// certificate's identity. Nil disables client certificate authentication.
+	Certificates *auth.UserStore
+	// GenerateKey creates and persists the signer RotateKey rotates to, e.g. KeyDir.Generate. Without it
+	// keys cannot be rotated through the API.
+	GenerateKey func() (crypto.Signer, error)
+}
+
+// Server serves the API with the dependencies of its Config.
+type Server struct {
+	config Config
+
+	// currentLists remembers, per kind of list, the list the last reference was allocated in,
+	// so allocation only searches the lists again once that one is sealed, and holds the
+	// locks making that search and the creation of a new list one request at a time.
+	currentLists struct {
+		sync.Mutex
+		ids   map[listKind]string
+		locks map[listKind]*sync.Mutex
+	}
+}
+
+// NewServer creates a Server with cfg, defaulting ListCapacity to status.DefaultSize.
+func NewServer(cfg Config) *Server {
+	if cfg.ListCapacity == 0 {
+		cfg.ListCapacity = status.DefaultSize
+	}
+	s := &Server{config: cfg}
+	s.currentLists.ids = make(map[listKind]string)
+	s.currentLists.locks = make(map[listKind]*sync.Mutex)
+	return s
+}
+
+// SetupRouter returns the router of a new Server with cfg.
+func SetupRouter(cfg Config) *mux.Router {
+	return NewServer(cfg).Router()
+}
+
+// Router routes the API's endpoints to s.
+func (s *Server) Router() *mux.Router {
 	r := mux.NewRouter()
-	r.HandleFunc("/api/status/{statusId}", GetStatus).Methods("GET")
-	r.HandleFunc("/api/status/{statusId}/{index}", SetStatus).Methods("PUT")
-	r.HandleFunc("/api/status/{statusId}/{index}", DeleteStatus).Methods("DELETE")
-	r.HandleFunc("/api/status/{statusId}", CreateStatus).Methods("POST")
-	r.HandleFunc("/api/status", GetAllStatuses).Methods("GET")
-	r.HandleFunc("/api/status", CreateNewStructure).Methods("POST")
 
-	// Applying BasicAuth middleware to all PUT, POST, DELETE methods
-	r.Use(BasicAuth)
+	// Published lists and keys are read anonymously by any verifier and may be cached
+	public := r.NewRoute().Subrouter()
+	public.HandleFunc("/.well-known/jwks.json", s.GetJWKS).Methods("GET")
+	public.HandleFunc("/statuslists", s.GetStatusListAggregation).Methods("GET")
+	public.HandleFunc("/statuslists/{statusId}", s.GetStatusList).Methods("GET")
+	public.HandleFunc("/credentials/status/{statusId}", s.GetStatusListCredential).Methods("GET")
+	public.HandleFunc("/api/status/{statusId}", s.GetStatus).Methods("GET")
+	public.Use(s.PublicCache)
+
+	// Managing lists requires authentication and a role
+	management := r.NewRoute().Subrouter()
+	management.Handle("/api/status/{statusId}/{index}", s.requireRole(auth.RoleRevoker, s.SetStatus)).Methods("PUT")
+	management.Handle("/api/status/{statusId}/{index}", s.requireRole(auth.RoleRevoker, s.DeleteStatus)).Methods("DELETE")
+	management.Handle("/api/status/{statusId}/meta", s.requireRole(auth.RoleReader, s.GetStatusMeta)).Methods("GET")
+	management.Handle("/api/status/{statusId}/{index}/history", s.requireRole(auth.RoleReader, s.GetStatusHistory)).Methods("GET")
+	management.Handle("/api/status/{statusId}", s.requireRole(auth.RoleIssuer, s.CreateStatus)).Methods("POST")
+	management.Handle("/api/status/{statusId}", s.requireRole(auth.RoleAdmin, s.DeleteStructure)).Methods("DELETE")
+	management.Handle("/api/status/{statusId}", s.requireRole(auth.RoleRevoker, s.BulkUpdateStatus)).Methods("PATCH")
+	management.Handle("/api/status", s.requireRole(auth.RoleReader, s.GetAllStatuses)).Methods("GET")
+	management.Handle("/api/status", s.requireUnrestrictedRole(auth.RoleAdmin, s.CreateNewStructure)).Methods("POST")
+	management.Handle("/api/references", s.requireRole(auth.RoleIssuer, s.CreateReference)).Methods("POST")
+	management.Handle("/api/keys/rotate", s.requireUnrestrictedRole(auth.RoleAdmin, s.RotateKey)).Methods("POST")
+	management.Use(s.Authenticate)
 
 	return r
 }
+
+func (s *Server) requireRole(role auth.Role, handler http.HandlerFunc) http.Handler {
+	return s.RequireRole(role)(handler)
+}
+
+func (s *Server) requireUnrestrictedRole(role auth.Role, handler http.HandlerFunc) http.Handler {
+	return s.RequireUnrestrictedRole(role)(handler)
+}
@@ -1,20 +1,23 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
 	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
 	"github.com/korentmaj/go-ecdsa-status-netis-challenge/pkg/models"
 )
 
-func GetStatus(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	statusId := vars["statusId"]
 	indexStr := r.URL.Query().Get("index")
@@ -26,13 +29,8 @@ func GetStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, err := models.GetStatus(statusId)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Status not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Failed to query status", http.StatusInternalServerError)
+	status, ok := s.loadStatus(w, statusId)
+	if !ok {
 		return
 	}
 
@@ -42,25 +40,266 @@ func GetStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	iat := time.Now().Unix()
-	exp := time.Now().Add(24 * time.Hour).Unix()
-	domain := "localhost" // vstavi lastno domeno
+	now := time.Now()
 
 	payload := map[string]interface{}{
-		"iat": iat,
-		"exp": exp,
-		"iss": fmt.Sprintf("http://%s/api/status/%s", domain, statusId),
+		"iat": now.Unix(),
+		"exp": now.Add(s.config.TokenLifetime).Unix(),
+		"iss": fmt.Sprintf("%s/api/status/%s", s.config.BaseURL, statusId),
 		"status": map[string]interface{}{
+			"bits":        status.Bits(),
 			"encodedList": encodedList,
 			"index":       index,
 		},
 	}
 
+	token, err := crypto.SignJWS(s.config.Keys.Active(), crypto.StatusListJWTType, payload)
+	if err != nil {
+		http.Error(w, "Failed to sign status token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", crypto.StatusListContentType)
+	w.Write([]byte(token))
+}
+
+// GetStatusList returns the list as an IETF Token Status List token. With a "time" query
+// parameter (Unix seconds) it returns the list as it was at that time instead, in a token
+// marked historical.
+func (s *Server) GetStatusList(w http.ResponseWriter, r *http.Request) {
+	statusId := mux.Vars(r)["statusId"]
+
+	if timeStr := r.URL.Query().Get("time"); timeStr != "" {
+		s.getHistoricalStatusList(w, statusId, timeStr)
+		return
+	}
+
+	status, ok := s.loadStatus(w, statusId)
+	if !ok {
+		return
+	}
+
+	lst, err := status.EncodeLst()
+	if err != nil {
+		http.Error(w, "Failed to encode status list", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	payload := map[string]interface{}{
+		"sub": s.statusListURI(statusId),
+		"iss": s.config.BaseURL,
+		"iat": now.Unix(),
+		"exp": now.Add(s.config.TokenLifetime).Unix(),
+		"ttl": int64(s.config.TokenTTL.Seconds()),
+		"status_list": map[string]interface{}{
+			"bits": status.Bits(),
+			"lst":  lst,
+		},
+	}
+
+	token, err := crypto.SignJWS(s.config.Keys.Active(), crypto.StatusListJWTType, payload)
+	if err != nil {
+		http.Error(w, "Failed to sign status token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", crypto.StatusListContentType)
+	w.Write([]byte(token))
+}
+
+// getHistoricalStatusList answers with a token of the list reconstructed as of timeStr.
+// The token attests what the list was, not what it is: its "typ" is
+// statuslist-historical+jwt, its "sub" is the URI it was fetched from rather than the
+// list's, and it carries "historical" and the reconstructed "time", so it never passes as
+// current. It expires like any token and has no "ttl".
+func (s *Server) getHistoricalStatusList(w http.ResponseWriter, statusId, timeStr string) {
+	unix, err := strconv.ParseInt(timeStr, 10, 64)
+	if err != nil || unix < 0 {
+		http.Error(w, "Invalid time", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if unix > now.Unix() {
+		http.Error(w, "Time must not be in the future", http.StatusBadRequest)
+		return
+	}
+
+	// The whole second counts, so "as of t" includes changes made during second t
+	status, err := s.config.Repo.StatusListAt(statusId, time.Unix(unix+1, 0).Add(-time.Nanosecond))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotFound):
+			http.Error(w, "Status not found", http.StatusNotFound)
+		case errors.Is(err, models.ErrNotYetCreated):
+			http.Error(w, "Status list did not exist at that time", http.StatusNotFound)
+		default:
+			log.Printf("Failed to reconstruct status %s: %v", statusId, err)
+			http.Error(w, "Failed to reconstruct status list", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	lst, err := status.EncodeLst()
+	if err != nil {
+		http.Error(w, "Failed to encode status list", http.StatusInternalServerError)
+		return
+	}
+
+	payload := map[string]interface{}{
+		"sub":        fmt.Sprintf("%s?time=%d", s.statusListURI(statusId), unix),
+		"iss":        s.config.BaseURL,
+		"iat":        now.Unix(),
+		"exp":        now.Add(s.config.TokenLifetime).Unix(),
+		"historical": true,
+		"time":       unix,
+		"status_list": map[string]interface{}{
+			"bits": status.Bits(),
+			"lst":  lst,
+		},
+	}
+
+	token, err := crypto.SignJWS(s.config.Keys.Active(), crypto.HistoricalStatusListJWTType, payload)
+	if err != nil {
+		http.Error(w, "Failed to sign status token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", crypto.HistoricalStatusListContentType)
+	w.Write([]byte(token))
+}
+
+// GetStatusListAggregation returns a signed IETF Status List Aggregation of every published
+// list, so verifiers can fetch all lists ahead of time. The "purpose" query parameter
+// selects the lists of one purpose, "issuer" those of one issuer, which is this server or none.
+func (s *Server) GetStatusListAggregation(w http.ResponseWriter, r *http.Request) {
+	purpose := r.URL.Query().Get("purpose")
+	if purpose != "" && !crypto.ValidStatusPurpose(purpose) {
+		http.Error(w, "Purpose must be revocation, suspension or message", http.StatusBadRequest)
+		return
+	}
+
+	metas, err := s.config.Repo.ListStatusMeta()
+	if err != nil {
+		log.Printf("Failed to list status metadata: %v", err)
+		http.Error(w, "Failed to list status lists", http.StatusInternalServerError)
+		return
+	}
+
+	statusLists := []string{}
+	if issuer := r.URL.Query().Get("issuer"); issuer == "" || issuer == s.config.BaseURL {
+		for i := range metas {
+			if purpose == "" || listPurpose(&metas[i]) == purpose {
+				statusLists = append(statusLists, s.statusListURI(metas[i].StatusId))
+			}
+		}
+	}
+
+	now := time.Now()
+	payload := map[string]interface{}{
+		"iss":          s.config.BaseURL,
+		"iat":          now.Unix(),
+		"exp":          now.Add(s.config.TokenLifetime).Unix(),
+		"ttl":          int64(s.config.TokenTTL.Seconds()),
+		"status_lists": statusLists,
+	}
+
+	token, err := crypto.SignJWS(s.config.Keys.Active(), crypto.AggregationJWTType, payload)
+	if err != nil {
+		http.Error(w, "Failed to sign status list aggregation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", crypto.AggregationContentType)
+	w.Write([]byte(token))
+}
+
+// GetStatusListCredential returns the list as a W3C BitstringStatusListCredential secured as a VC-JWT.
+func (s *Server) GetStatusListCredential(w http.ResponseWriter, r *http.Request) {
+	statusId := mux.Vars(r)["statusId"]
+
+	status, ok := s.loadStatus(w, statusId)
+	if !ok {
+		return
+	}
+
+	meta, err := s.config.Repo.GetStatusMeta(statusId)
+	if err != nil {
+		log.Printf("Failed to query metadata of status %s: %v", statusId, err)
+		http.Error(w, "Failed to query status metadata", http.StatusInternalServerError)
+		return
+	}
+
+	// The purpose is fixed when the list is created; a verifier must not be handed another one
+	purpose := listPurpose(meta)
+	if requested := r.URL.Query().Get("purpose"); requested != "" && requested != purpose {
+		http.Error(w, "Purpose does not match the status list", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	id := fmt.Sprintf("%s/credentials/status/%s", s.config.BaseURL, statusId)
+	credential, err := crypto.NewBitstringStatusListCredential(id, s.config.BaseURL, purpose, status, now, now.Add(s.config.TokenLifetime), s.config.TokenTTL)
+	if err != nil {
+		log.Printf("Failed to build credential of status %s: %v", statusId, err)
+		http.Error(w, "Failed to build status list credential", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := crypto.SignCredential(s.config.Keys.Active(), credential)
+	if err != nil {
+		http.Error(w, "Failed to sign status list credential", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", crypto.VCJWTContentType)
+	w.Write([]byte(token))
+}
+
+// jwksMaxAge is how long verifiers may cache the key set. It is short so a rotated-in key is picked up quickly.
+const jwksMaxAge = 5 * time.Minute
+
+// GetJWKS publishes the public halves of the active and retired signing keys.
+func (s *Server) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", crypto.JWKSContentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(s.config.Keys.JWKS())
+}
+
+// RotateKey makes a freshly generated key the active signing key. The previous key stays
+// published in the JWKS for the token lifetime, so tokens it signed remain verifiable.
+func (s *Server) RotateKey(w http.ResponseWriter, r *http.Request) {
+	if s.config.GenerateKey == nil {
+		http.Error(w, "Key rotation needs a key directory", http.StatusNotImplemented)
+		return
+	}
+
+	previous := s.config.Keys.Active().KeyID()
+	next, err := s.config.GenerateKey()
+	if err != nil {
+		log.Printf("Failed to generate signing key: %v", err)
+		http.Error(w, "Failed to generate signing key", http.StatusInternalServerError)
+		return
+	}
+	if err := s.config.Keys.Rotate(next); err != nil {
+		log.Printf("Failed to rotate signing key: %v", err)
+		http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		return
+	}
+
+	principal, _ := PrincipalFromContext(r.Context())
+	log.Printf("%s rotated the signing key from %s to %s", principal.Name, previous, next.KeyID())
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(payload)
+	json.NewEncoder(w).Encode(map[string]string{"kid": next.KeyID(), "retired": previous})
+}
+
+// statusListURI returns the URI under which a list is published, the "sub" of its tokens.
+func (s *Server) statusListURI(statusId string) string {
+	return fmt.Sprintf("%s/statuslists/%s", s.config.BaseURL, statusId)
 }
 
-func SetStatus(w http.ResponseWriter, r *http.Request) {
+func (s *Server) SetStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	statusId := vars["statusId"]
 	index, err := strconv.Atoi(vars["index"])
@@ -69,26 +308,31 @@ func SetStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, err := models.GetStatus(statusId)
-	if err != nil {
-		http.Error(w, "Status not found", http.StatusNotFound)
-		return
+	// PUT sets the status to 1 (INVALID) unless another value is requested
+	value := uint64(1)
+	if valueStr := r.URL.Query().Get("value"); valueStr != "" {
+		value, err = strconv.ParseUint(valueStr, 10, 8)
+		if err != nil {
+			http.Error(w, "Invalid value", http.StatusBadRequest)
+			return
+		}
 	}
 
-	if err := status.SetStatus(index, true); err != nil {
-		http.Error(w, "Failed to set status", http.StatusInternalServerError)
+	saved, err := s.updateStatus(w, r, statusId, func(status *status.StatusList) error {
+		return status.Set(index, uint8(value))
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set status: %v", err), http.StatusBadRequest)
 		return
 	}
-
-	if err := models.SaveStatus(statusId, status); err != nil {
-		http.Error(w, "Failed to save status", http.StatusInternalServerError)
+	if !saved {
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func DeleteStatus(w http.ResponseWriter, r *http.Request) {
+func (s *Server) DeleteStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	statusId := vars["statusId"]
 	index, err := strconv.Atoi(vars["index"])
@@ -97,60 +341,225 @@ func DeleteStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status, err := models.GetStatus(statusId)
+	saved, err := s.updateStatus(w, r, statusId, func(status *status.StatusList) error {
+		return status.SetStatus(index, false)
+	})
 	if err != nil {
-		http.Error(w, "Status not found", http.StatusNotFound)
+		http.Error(w, fmt.Sprintf("Failed to set status: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !saved {
 		return
 	}
 
-	if err := status.SetStatus(index, false); err != nil {
-		http.Error(w, "Failed to set status", http.StatusInternalServerError)
+	w.WriteHeader(http.StatusOK)
+}
+
+// maxBulkBodySize bounds the body of a bulk update.
+const maxBulkBodySize = 32 << 20
+
+// bulkOperation sets one status in a bulk update. Value defaults to 1 (INVALID), as with PUT.
+type bulkOperation struct {
+	Index *int `json:"index"`
+	Value *int `json:"value"`
+}
+
+// bulkResult reports the outcome of one bulk operation.
+type bulkResult struct {
+	Index    int    `json:"index"`
+	Value    int    `json:"value"`
+	Previous *uint8 `json:"previous,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BulkUpdateStatus applies a JSON array, or with Content-Type application/x-ndjson a stream,
+// of {"index", "value"} operations to a list in one update. Either every operation is applied
+// or, if any is invalid, none is; the response lists the result of each operation in order.
+func (s *Server) BulkUpdateStatus(w http.ResponseWriter, r *http.Request) {
+	statusId := mux.Vars(r)["statusId"]
+
+	operations, err := readBulkOperations(http.MaxBytesReader(w, r.Body, maxBulkBodySize), r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid operations: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(operations) == 0 {
+		http.Error(w, "No operations", http.StatusBadRequest)
 		return
 	}
 
-	if err := models.SaveStatus(statusId, status); err != nil {
-		http.Error(w, "Failed to save status", http.StatusInternalServerError)
+	var results []bulkResult
+	saved, err := s.updateStatus(w, r, statusId, func(status *status.StatusList) error {
+		results = make([]bulkResult, len(operations))
+		failed := 0
+		for i, operation := range operations {
+			var opErr error
+			results[i], opErr = applyBulkOperation(status, operation)
+			if opErr != nil {
+				results[i].Error = opErr.Error()
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d operations failed", failed, len(operations))
+		}
+		return nil
+	})
+	if err != nil {
+		// Nothing was applied, so there is no previous value to report
+		for i := range results {
+			results[i].Previous = nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"applied": false, "error": err.Error(), "results": results})
+		return
+	}
+	if !saved {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"applied": true, "results": results})
 }
 
-func CreateStatus(w http.ResponseWriter, r *http.Request) {
+// readBulkOperations decodes a JSON array of operations, or newline delimited ones for an NDJSON content type.
+func readBulkOperations(body io.Reader, contentType string) ([]bulkOperation, error) {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/x-ndjson" && mediaType != "application/jsonl" {
+		var operations []bulkOperation
+		if err := decoder.Decode(&operations); err != nil {
+			return nil, err
+		}
+		return operations, nil
+	}
+
+	var operations []bulkOperation
+	for {
+		var operation bulkOperation
+		if err := decoder.Decode(&operation); err != nil {
+			if err == io.EOF {
+				return operations, nil
+			}
+			return nil, fmt.Errorf("line %d: %v", len(operations)+1, err)
+		}
+		operations = append(operations, operation)
+	}
+}
+
+func applyBulkOperation(status *status.StatusList, operation bulkOperation) (bulkResult, error) {
+	if operation.Index == nil {
+		return bulkResult{}, errors.New("missing index")
+	}
+	result := bulkResult{Index: *operation.Index, Value: 1}
+	if operation.Value != nil {
+		result.Value = *operation.Value
+	}
+	if result.Value < 0 || result.Value > 255 {
+		return result, errors.New("invalid value")
+	}
+
+	previous, err := status.Get(result.Index)
+	if err != nil {
+		return result, err
+	}
+	if err := status.Set(result.Index, uint8(result.Value)); err != nil {
+		return result, err
+	}
+	result.Previous = &previous
+	return result, nil
+}
+
+func (s *Server) CreateStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	statusId := vars["statusId"]
 
-	status, err := models.GetStatus(statusId)
+	var index int
+	saved, err := s.updateStatus(w, r, statusId, func(statusList *status.StatusList) error {
+		var err error
+		index, err = statusList.AddStatus(false)
+		return err
+	})
 	if err != nil {
-		http.Error(w, "Status not found", http.StatusNotFound)
+		if err == status.ErrListFull {
+			http.Error(w, "Status list is full", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to add status", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
 		return
 	}
 
-	index := status.AddStatus(false)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"index": index})
+}
+
+// GetStatusHistory returns the recorded changes of one status entry, oldest first.
+func (s *Server) GetStatusHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	statusId := vars["statusId"]
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
 
-	if err := models.SaveStatus(statusId, status); err != nil {
-		http.Error(w, "Failed to save status", http.StatusInternalServerError)
+	history, err := s.config.Repo.StatusHistory(statusId, index)
+	if err != nil {
+		log.Printf("Failed to query history of status %s: %v", statusId, err)
+		http.Error(w, "Failed to query status history", http.StatusInternalServerError)
 		return
 	}
+	// Without events the list may not exist at all; the history of a deleted list is still served
+	if len(history) == 0 {
+		if _, ok := s.loadStatus(w, statusId); !ok {
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{"index": index})
+	json.NewEncoder(w).Encode(history)
 }
 
-func GetAllStatuses(w http.ResponseWriter, r *http.Request) {
-	statusIds, err := models.GetAllStatusIds()
+// GetAllStatuses returns the IDs of the lists the caller may access.
+func (s *Server) GetAllStatuses(w http.ResponseWriter, r *http.Request) {
+	statusIds, err := s.config.Repo.GetAllStatusIds()
 	if err != nil {
 		http.Error(w, "Failed to get status ids", http.StatusInternalServerError)
 		return
 	}
 
+	principal, _ := PrincipalFromContext(r.Context())
+	accessible := []string{}
+	for _, statusId := range statusIds {
+		if principal.CanAccess(statusId) {
+			accessible = append(accessible, statusId)
+		}
+	}
+	statusIds = accessible
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(statusIds)
 }
 
-func CreateNewStructure(w http.ResponseWriter, r *http.Request) {
-	status := status.NewStatusList()
-	statusId, err := models.CreateNewStatus(status)
+func (s *Server) CreateNewStructure(w http.ResponseWriter, r *http.Request) {
+	bits, purpose, ok := listParameters(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := status.NewStatusListWithSize(bits, s.config.ListCapacity)
+	if err != nil {
+		http.Error(w, "Failed to create status list", http.StatusInternalServerError)
+		return
+	}
+
+	statusId, err := s.config.Repo.CreateNewStatus(status, purpose)
 	if err != nil {
 		http.Error(w, "Failed to create new status", http.StatusInternalServerError)
 		return
@@ -159,3 +568,144 @@ func CreateNewStructure(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"statusId": statusId})
 }
+
+// listParameters reads the "bits" and "purpose" query parameters of a new list, answering 400 itself when they are invalid.
+func listParameters(w http.ResponseWriter, r *http.Request) (int, string, bool) {
+	bits := 1
+	if bitsStr := r.URL.Query().Get("bits"); bitsStr != "" {
+		var err error
+		bits, err = strconv.Atoi(bitsStr)
+		if err != nil {
+			http.Error(w, "Invalid bits", http.StatusBadRequest)
+			return 0, "", false
+		}
+	}
+	if !status.ValidBits(bits) {
+		http.Error(w, "Bits must be 1, 2, 4 or 8", http.StatusBadRequest)
+		return 0, "", false
+	}
+
+	purpose := r.URL.Query().Get("purpose")
+	if purpose == "" {
+		purpose = defaultPurpose(bits)
+	}
+	if !crypto.ValidStatusPurpose(purpose) {
+		http.Error(w, "Purpose must be revocation, suspension or message", http.StatusBadRequest)
+		return 0, "", false
+	}
+	if purpose != crypto.PurposeMessage && bits != 1 {
+		http.Error(w, fmt.Sprintf("Purpose %s requires 1 bit per status", purpose), http.StatusBadRequest)
+		return 0, "", false
+	}
+
+	return bits, purpose, true
+}
+
+// defaultPurpose is the purpose of a list created without one: revocation, or message for multi-bit lists.
+func defaultPurpose(bits int) string {
+	if bits > 1 {
+		return crypto.PurposeMessage
+	}
+	return crypto.PurposeRevocation
+}
+
+// listPurpose returns the purpose of a list, falling back to the default for lists stored without one.
+func listPurpose(meta *models.StatusMeta) string {
+	if meta.Purpose == "" {
+		return defaultPurpose(meta.Bits)
+	}
+	return meta.Purpose
+}
+
+// GetStatusMeta describes a list: purpose, bits, capacity, used entries and when it was created and sealed.
+func (s *Server) GetStatusMeta(w http.ResponseWriter, r *http.Request) {
+	statusId := mux.Vars(r)["statusId"]
+
+	meta, err := s.config.Repo.GetStatusMeta(statusId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Status not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to query metadata of status %s: %v", statusId, err)
+		http.Error(w, "Failed to query status metadata", http.StatusInternalServerError)
+		return
+	}
+	meta.Purpose = listPurpose(meta)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+func (s *Server) DeleteStructure(w http.ResponseWriter, r *http.Request) {
+	statusId := mux.Vars(r)["statusId"]
+
+	if err := s.config.Repo.DeleteStatus(statusId); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Status not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete status", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadStatus fetches a list from the repository, answering 404 or 500 itself when that fails.
+func (s *Server) loadStatus(w http.ResponseWriter, statusId string) (*status.StatusList, bool) {
+	statusList, err := s.config.Repo.GetStatus(statusId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Status not found", http.StatusNotFound)
+			return nil, false
+		}
+		log.Printf("Failed to query status %s: %v", statusId, err)
+		http.Error(w, "Failed to query status", http.StatusInternalServerError)
+		return nil, false
+	}
+	return statusList, true
+}
+
+// maxReasonLength bounds the reason code recorded with a status change.
+const maxReasonLength = 255
+
+// updateStatus changes a list through the repository with fn, on behalf of the request's
+// principal and for the reason given in the "reason" query parameter. When the change cannot
+// be stored it answers itself and returns false; an error from fn is returned for the caller to answer.
+func (s *Server) updateStatus(w http.ResponseWriter, r *http.Request, statusId string, fn func(*status.StatusList) error) (bool, error) {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthenticated", http.StatusUnauthorized)
+		return false, nil
+	}
+
+	reason := r.URL.Query().Get("reason")
+	if len(reason) > maxReasonLength {
+		http.Error(w, fmt.Sprintf("Reason must not be longer than %d bytes", maxReasonLength), http.StatusBadRequest)
+		return false, nil
+	}
+
+	var fnErr error
+	err := s.config.Repo.UpdateStatus(statusId, models.Audit{Actor: principal.Name, Reason: reason}, func(statusList *status.StatusList) error {
+		fnErr = fn(statusList)
+		return fnErr
+	})
+	if fnErr != nil {
+		return false, fnErr
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNotFound):
+			http.Error(w, "Status not found", http.StatusNotFound)
+		case errors.Is(err, models.ErrConflict):
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Status list is busy, retry later", http.StatusServiceUnavailable)
+		default:
+			log.Printf("Failed to update status %s: %v", statusId, err)
+			http.Error(w, "Failed to save status", http.StatusInternalServerError)
+		}
+		return false, nil
+	}
+	return true, nil
+}
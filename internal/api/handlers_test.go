@@ -0,0 +1,1058 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/pkg/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestServer starts the API on an in-memory repository with a fresh signing key.
+func newTestServer(t *testing.T) (*httptest.Server, crypto.Signer) {
+	return newTestServerWithCapacity(t, 0)
+}
+
+// newTestServerWithCapacity starts the API like newTestServer, creating lists of listCapacity entries.
+func newTestServerWithCapacity(t *testing.T, listCapacity int) (*httptest.Server, crypto.Signer) {
+	return newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.ListCapacity = listCapacity
+	})
+}
+
+// newTestServerWithRepo starts the API like newTestServer and returns its repository, so
+// tests can check what was stored.
+func newTestServerWithRepo(t *testing.T) (*httptest.Server, models.StatusRepository) {
+	repo := models.NewMemoryRepository()
+	server, _ := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.Repo = repo
+	})
+	return server, repo
+}
+
+// testListURI returns the URI the test servers publish a list under.
+func testListURI(statusId string) string {
+	return "http://status.test/statuslists/" + statusId
+}
+
+// newTestServerWithConfig starts the API like newTestServer after configure adjusted its configuration.
+func newTestServerWithConfig(t *testing.T, configure func(*Config)) (*httptest.Server, crypto.Signer) {
+	signer, err := crypto.GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+
+	// The minimum bcrypt cost keeps the many authenticated requests of the tests fast
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	keyDir, err := crypto.OpenKeyDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error opening key directory: %v", err)
+	}
+
+	cfg := Config{
+		Repo:          models.NewMemoryRepository(),
+		Keys:          crypto.NewKeyRegistry(signer, time.Hour),
+		BaseURL:       "http://status.test",
+		TokenLifetime: time.Hour,
+		TokenTTL:      time.Minute,
+		Users: auth.NewUserStore(
+			auth.User{Name: "user", PasswordHash: string(hash), Grant: auth.Grant{Roles: []auth.Role{auth.RoleAdmin}}},
+			auth.User{Name: "reader", PasswordHash: string(hash), Grant: auth.Grant{Roles: []auth.Role{auth.RoleReader}}},
+			auth.User{Name: "issuer", PasswordHash: string(hash), Grant: auth.Grant{Roles: []auth.Role{auth.RoleIssuer}}},
+			auth.User{Name: "revoker", PasswordHash: string(hash), Grant: auth.Grant{Roles: []auth.Role{auth.RoleRevoker}}},
+			auth.User{Name: "scoped", PasswordHash: string(hash), Grant: auth.Grant{Roles: []auth.Role{auth.RoleReader, auth.RoleIssuer, auth.RoleRevoker}, Lists: []string{"1"}}},
+			auth.User{Name: "scopedadmin", PasswordHash: string(hash), Grant: auth.Grant{Roles: []auth.Role{auth.RoleAdmin}, Lists: []string{"1"}}},
+		),
+		GenerateKey: keyDir.Generate,
+	}
+	configure(&cfg)
+
+	server := httptest.NewServer(SetupRouter(cfg))
+	t.Cleanup(server.Close)
+
+	return server, signer
+}
+
+// do sends a request authenticated as the admin "user" and returns the response status and body.
+func do(t *testing.T, server *httptest.Server, method, path string, body string) (int, []byte) {
+	return doAs(t, server, "user", method, path, body)
+}
+
+// createList creates a list with the query's bits and purpose as the admin "user" and returns its ID.
+func createList(t *testing.T, server *httptest.Server, query string) string {
+	code, body := do(t, server, "POST", "/api/status"+query, "")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 creating list, got %d: %s", code, body)
+	}
+	var created map[string]string
+	if err := json.Unmarshal(body, &created); err != nil || created["statusId"] == "" {
+		t.Fatalf("Expected the ID of the new list, got %s", body)
+	}
+	return created["statusId"]
+}
+
+// doAs sends a request authenticated as one of the test users, which all have the password "password".
+func doAs(t *testing.T, server *httptest.Server, user, method, path string, body string) (int, []byte) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	req.SetBasicAuth(user, "password")
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error sending %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	return resp.StatusCode, data
+}
+
+func TestHandlers(t *testing.T) {
+	server, signer := newTestServer(t)
+
+	// Create a 2-bit list
+	statusId := createList(t, server, "?bits=2")
+
+	// Allocate an index
+	code, body := do(t, server, "POST", "/api/status/"+statusId, "")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 allocating index, got %d: %s", code, body)
+	}
+	var allocated map[string]int
+	json.Unmarshal(body, &allocated)
+	index := allocated["index"]
+
+	// Suspend it
+	if code, body := do(t, server, "PUT", fmt.Sprintf("/api/status/%s/%d?value=2&reason=suspected", statusId, index), ""); code != http.StatusOK {
+		t.Fatalf("Expected 200 setting status, got %d: %s", code, body)
+	}
+	if code, _ := do(t, server, "PUT", fmt.Sprintf("/api/status/%s/%d?value=4", statusId, index), ""); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a value wider than 2 bits, got %d", code)
+	}
+
+	// The published token carries the change
+	code, body = do(t, server, "GET", "/statuslists/"+statusId, "")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 getting status list, got %d: %s", code, body)
+	}
+	claims, err := crypto.ParseStatusListToken(body, signer.Public().(*ecdsa.PublicKey))
+	if err != nil {
+		t.Fatalf("Error parsing status list token: %v", err)
+	}
+	if claims.Subject != "http://status.test/statuslists/"+statusId || claims.TTL != 60 {
+		t.Fatalf("Unexpected claims: %+v", claims)
+	}
+	list, err := status.DecodeLst(claims.StatusList.Lst, claims.StatusList.Bits)
+	if err != nil {
+		t.Fatalf("Error decoding status list: %v", err)
+	}
+	if value, _ := list.Get(index); value != 2 {
+		t.Fatalf("Expected status 2 at index %d, got %d", index, value)
+	}
+
+	// Clear it again
+	if code, body := do(t, server, "DELETE", fmt.Sprintf("/api/status/%s/%d", statusId, index), ""); code != http.StatusOK {
+		t.Fatalf("Expected 200 clearing status, got %d: %s", code, body)
+	}
+	code, body = do(t, server, "GET", fmt.Sprintf("/api/status/%s?index=%d", statusId, index), "")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 getting status, got %d: %s", code, body)
+	}
+	legacy, err := crypto.ParseJWSResponse(body, signer.Public().(*ecdsa.PublicKey))
+	if err != nil {
+		t.Fatalf("Error parsing status token: %v", err)
+	}
+	list, err = status.Decode(legacy.EncodedList, legacy.Bits)
+	if err != nil {
+		t.Fatalf("Error decoding status list: %v", err)
+	}
+	if value, _ := list.Get(index); value != 0 {
+		t.Fatalf("Expected status 0 at index %d, got %d", index, value)
+	}
+
+	// List and delete
+	code, body = do(t, server, "GET", "/api/status", "")
+	if code != http.StatusOK || strings.TrimSpace(string(body)) != fmt.Sprintf(`["%s"]`, statusId) {
+		t.Fatalf("Expected [%q], got %d: %s", statusId, code, body)
+	}
+	if code, _ := do(t, server, "DELETE", "/api/status/"+statusId, ""); code != http.StatusNoContent {
+		t.Fatalf("Expected 204 deleting list, got %d", code)
+	}
+	if code, _ := do(t, server, "GET", "/statuslists/"+statusId, ""); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a deleted list, got %d", code)
+	}
+	if code, _ := do(t, server, "POST", "/api/status/"+statusId, ""); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 allocating in a deleted list, got %d", code)
+	}
+
+	// The history of the entry outlives the list
+	code, body = do(t, server, "GET", fmt.Sprintf("/api/status/%s/%d/history", statusId, index), "")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 getting history, got %d: %s", code, body)
+	}
+	var history []models.StatusEvent
+	if err := json.Unmarshal(body, &history); err != nil {
+		t.Fatalf("Error decoding history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 events, got %s", body)
+	}
+	if history[0].NewValue != 2 || history[0].Actor != "user" || history[0].Reason != "suspected" {
+		t.Fatalf("Unexpected first event: %+v", history[0])
+	}
+	if history[1].OldValue != 2 || history[1].NewValue != 0 || history[1].Reason != "" {
+		t.Fatalf("Unexpected second event: %+v", history[1])
+	}
+	if code, _ := do(t, server, "GET", "/api/status/999/0/history", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for the history of an unknown list, got %d", code)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	for name, setAuth := range map[string]func(*http.Request){
+		"no credentials": func(*http.Request) {},
+		"wrong password": func(req *http.Request) { req.SetBasicAuth("user", "pass") },
+		"unknown user":   func(req *http.Request) { req.SetBasicAuth("mallory", "password") },
+		"bearer token":   func(req *http.Request) { req.Header.Set("Authorization", "Bearer password") },
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"/api/status", nil)
+		setAuth(req)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Error sending request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Fatalf("Expected 401 with a challenge for %s, got %d", name, resp.StatusCode)
+		}
+	}
+
+	if code, body := do(t, server, "GET", "/api/status", ""); code != http.StatusOK {
+		t.Fatalf("Expected 200 with valid credentials, got %d: %s", code, body)
+	}
+}
+
+func TestPublicRoutes(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	statusId := createList(t, server, "")
+
+	anonymous := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Error sending %s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for path, cacheControl := range map[string]string{
+		"/.well-known/jwks.json":                   "public, max-age=300",
+		"/statuslists":                             "public, max-age=60",
+		"/statuslists/" + statusId:                 "public, max-age=60",
+		"/credentials/status/" + statusId:          "public, max-age=60",
+		"/api/status/" + statusId + "?index=4711":  "public, max-age=60",
+		"/statuslists/" + statusId + "?time=bogus": "no-store",
+		"/statuslists/404":                         "no-store",
+	} {
+		resp := anonymous("GET", path)
+		if resp.StatusCode == http.StatusUnauthorized {
+			t.Fatalf("Expected %s to be public", path)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != cacheControl {
+			t.Fatalf("Expected Cache-Control %q for %s, got %q", cacheControl, path, got)
+		}
+		if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+			t.Fatalf("Expected %s to be readable from any origin", path)
+		}
+	}
+
+	for _, route := range [][2]string{
+		{"GET", "/api/status"},
+		{"POST", "/api/status"},
+		{"GET", "/api/status/" + statusId + "/meta"},
+		{"GET", "/api/status/" + statusId + "/4711/history"},
+		{"POST", "/api/status/" + statusId},
+		{"PATCH", "/api/status/" + statusId},
+		{"DELETE", "/api/status/" + statusId},
+		{"PUT", "/api/status/" + statusId + "/4711"},
+		{"DELETE", "/api/status/" + statusId + "/4711"},
+		{"POST", "/api/references"},
+	} {
+		if resp := anonymous(route[0], route[1]); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 for anonymous %s %s, got %d", route[0], route[1], resp.StatusCode)
+		}
+	}
+}
+
+func TestIndependentServers(t *testing.T) {
+	// Each server keeps its own configuration, so several can run side by side
+	first, firstSigner := newTestServer(t)
+	second, secondSigner := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.BaseURL = "http://other.test"
+	})
+
+	for server, kid := range map[*httptest.Server]string{first: firstSigner.KeyID(), second: secondSigner.KeyID()} {
+		_, body := do(t, server, "GET", "/.well-known/jwks.json", "")
+		var jwks crypto.JWKS
+		json.Unmarshal(body, &jwks)
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != kid {
+			t.Fatalf("Expected only key %s, got %s", kid, body)
+		}
+	}
+
+	_, body := do(t, first, "POST", "/api/references", "")
+	var reference map[string]StatusListReference
+	json.Unmarshal(body, &reference)
+	if reference["status_list"].URI != testListURI("1") {
+		t.Fatalf("Expected a reference in the first server's list, got %s", body)
+	}
+	if code, _ := do(t, second, "GET", "/statuslists/1", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected the second server not to have the first server's list, got %d", code)
+	}
+}
+
+func TestConcurrentSetStatus(t *testing.T) {
+	server, repo := newTestServerWithRepo(t)
+
+	statusId := createList(t, server, "")
+
+	// Revoke many entries of the same list at once
+	const revocations = 32
+	var wg sync.WaitGroup
+	failures := make(chan string, revocations)
+	for i := 0; i < revocations; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/api/status/%s/%d", server.URL, statusId, index), nil)
+			req.SetBasicAuth("user", "password")
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				failures <- err.Error()
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				failures <- resp.Status
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(failures)
+	for failure := range failures {
+		t.Fatalf("Failed to set status: %s", failure)
+	}
+
+	list, err := repo.GetStatus(statusId)
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	for index := 0; index < revocations; index++ {
+		if value, _ := list.Get(index); value != 1 {
+			t.Fatalf("Lost the revocation of index %d", index)
+		}
+	}
+}
+
+func TestHistoricalStatusList(t *testing.T) {
+	server, signer := newTestServer(t)
+	publicKey := signer.Public().(*ecdsa.PublicKey)
+
+	statusId := createList(t, server, "")
+
+	if code, body := do(t, server, "PUT", fmt.Sprintf("/api/status/%s/4711", statusId), ""); code != http.StatusOK {
+		t.Fatalf("Expected 200 setting status, got %d: %s", code, body)
+	}
+
+	now := time.Now().Unix()
+	code, body := do(t, server, "GET", fmt.Sprintf("/statuslists/%s?time=%d", statusId, now), "")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 getting historical list, got %d: %s", code, body)
+	}
+	claims, err := crypto.ParseHistoricalStatusListToken(body, publicKey)
+	if err != nil {
+		t.Fatalf("Error parsing historical token: %v", err)
+	}
+	wantSubject := fmt.Sprintf("%s?time=%d", testListURI(statusId), now)
+	if !claims.Historical || claims.Time != now || claims.Subject != wantSubject || claims.ExpiresAt == 0 || claims.TTL != 0 {
+		t.Fatalf("Expected a historical token of %s with exp and without ttl, got %+v", wantSubject, claims)
+	}
+
+	// A verifier of the current status never accepts it
+	if _, err := crypto.ParseStatusListToken(body, publicKey); err == nil {
+		t.Fatalf("Expected the historical token to be rejected as a status list token")
+	}
+	list, err := status.DecodeLst(claims.StatusList.Lst, claims.StatusList.Bits)
+	if err != nil {
+		t.Fatalf("Error decoding status list: %v", err)
+	}
+	if value, _ := list.Get(4711); value != 1 {
+		t.Fatalf("Expected index 4711 revoked as of %d, got %d", now, value)
+	}
+
+	// The current token is not marked historical
+	code, body = do(t, server, "GET", "/statuslists/"+statusId, "")
+	if claims, err := crypto.ParseStatusListToken(body, publicKey); err != nil || claims.Historical {
+		t.Fatalf("Expected a current token, got %d: %+v, %v", code, claims, err)
+	}
+
+	for query, want := range map[string]int{
+		"time=abc":                       http.StatusBadRequest,
+		fmt.Sprintf("time=%d", now+3600): http.StatusBadRequest,
+		fmt.Sprintf("time=%d", now-3600): http.StatusNotFound,
+	} {
+		if code, _ := do(t, server, "GET", fmt.Sprintf("/statuslists/%s?%s", statusId, query), ""); code != want {
+			t.Fatalf("Expected %d for %s, got %d", want, query, code)
+		}
+	}
+}
+
+func TestBulkUpdateStatus(t *testing.T) {
+	server, repo := newTestServerWithRepo(t)
+
+	statusId := createList(t, server, "?bits=2")
+
+	type response struct {
+		Applied bool `json:"applied"`
+		Results []struct {
+			Index    int    `json:"index"`
+			Value    int    `json:"value"`
+			Previous *uint8 `json:"previous"`
+			Error    string `json:"error"`
+		} `json:"results"`
+	}
+
+	// A JSON array, with value defaulting to 1
+	code, body := do(t, server, "PATCH", "/api/status/"+statusId+"?reason=compromise", `[{"index": 1}, {"index": 2, "value": 2}, {"index": 1, "value": 3}]`)
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 for a bulk update, got %d: %s", code, body)
+	}
+	var applied response
+	json.Unmarshal(body, &applied)
+	if !applied.Applied || len(applied.Results) != 3 || applied.Results[2].Value != 3 || *applied.Results[2].Previous != 1 {
+		t.Fatalf("Unexpected bulk result: %s", body)
+	}
+
+	// An NDJSON stream with one invalid operation applies nothing
+	req, _ := http.NewRequest("PATCH", server.URL+"/api/status/"+statusId, strings.NewReader("{\"index\": 3, \"value\": 1}\n{\"index\": 4, \"value\": 4}\n"))
+	req.SetBasicAuth("user", "password")
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error sending bulk update: %v", err)
+	}
+	var rejected response
+	json.NewDecoder(resp.Body).Decode(&rejected)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || rejected.Applied || len(rejected.Results) != 2 {
+		t.Fatalf("Expected a rejected bulk update, got %d: %+v", resp.StatusCode, rejected)
+	}
+	if rejected.Results[0].Error != "" || rejected.Results[1].Error == "" || rejected.Results[0].Previous != nil {
+		t.Fatalf("Expected only the second operation to fail, got %+v", rejected.Results)
+	}
+
+	list, err := repo.GetStatus(statusId)
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	for index, want := range map[int]uint8{1: 3, 2: 2, 3: 0} {
+		if value, _ := list.Get(index); value != want {
+			t.Fatalf("Expected %d at index %d, got %d", want, index, value)
+		}
+	}
+
+	// One event per changed entry, with the batch's reason
+	history, _ := repo.StatusHistory(statusId, 1)
+	if len(history) != 1 || history[0].NewValue != 3 || history[0].Reason != "compromise" {
+		t.Fatalf("Expected one event for index 1, got %+v", history)
+	}
+
+	for body, want := range map[string]int{
+		`[]`:                    http.StatusBadRequest,
+		`{"index": 1}`:          http.StatusBadRequest,
+		`[{"idx": 1}]`:          http.StatusBadRequest,
+		`[{"index": 99999999}]`: http.StatusBadRequest,
+	} {
+		if code, _ := do(t, server, "PATCH", "/api/status/"+statusId, body); code != want {
+			t.Fatalf("Expected %d for %s, got %d", want, body, code)
+		}
+	}
+	if code, _ := do(t, server, "PATCH", "/api/status/999", `[{"index": 1}]`); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown list, got %d", code)
+	}
+}
+
+func TestCreateReference(t *testing.T) {
+	server, repo := newTestServerWithRepo(t)
+
+	// A full 1-bit list and a 2-bit list with room
+	full, _ := status.NewStatusListWithSize(1, 8)
+	for i := 0; i < 8; i++ {
+		full.Allocate()
+	}
+	fullId, _ := repo.CreateNewStatus(full, "")
+	roomy, _ := status.NewStatusListWithSize(2, 8)
+	roomyId, _ := repo.CreateNewStatus(roomy, "")
+
+	reference := func(query string) StatusListReference {
+		code, body := do(t, server, "POST", "/api/references"+query, "")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 allocating a reference, got %d: %s", code, body)
+		}
+		var response map[string]StatusListReference
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatalf("Error decoding reference: %v", err)
+		}
+		return response["status_list"]
+	}
+
+	// Neither list fits a 1-bit reference, so a new list is created and then reused
+	first := reference("")
+	second := reference("")
+	if first.URI == testListURI(fullId) || first.URI == testListURI(roomyId) {
+		t.Fatalf("Expected a new list, got %s", first.URI)
+	}
+	if second.URI != first.URI || second.Index == first.Index {
+		t.Fatalf("Expected two entries of %s, got %+v and %+v", first.URI, first, second)
+	}
+
+	newId := strings.TrimPrefix(first.URI, "http://status.test/statuslists/")
+	list, err := repo.GetStatus(newId)
+	if err != nil {
+		t.Fatalf("Error getting new list: %v", err)
+	}
+	if list.Bits() != 1 || list.Used() != 2 || !list.Allocated(first.Index) || !list.Allocated(second.Index) {
+		t.Fatalf("Expected both references allocated in list %s", newId)
+	}
+
+	// A 2-bit reference goes to the list with room
+	if ref := reference("?bits=2"); ref.URI != testListURI(roomyId) || ref.Index < 0 || ref.Index >= 8 {
+		t.Fatalf("Expected an entry of %s, got %+v", testListURI(roomyId), ref)
+	}
+
+	if code, _ := do(t, server, "POST", "/api/references?bits=3", ""); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for 3 bits, got %d", code)
+	}
+}
+
+func TestConcurrentReferences(t *testing.T) {
+	// Searching slowly lets all requests find that there is no list yet
+	server, _ := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.Repo = slowRepository{models.NewMemoryRepository()}
+	})
+
+	// Requests that all find no list create only one between them
+	const references = 16
+	var wg sync.WaitGroup
+	failures := make(chan string, references)
+	for i := 0; i < references; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", server.URL+"/api/references", nil)
+			req.SetBasicAuth("user", "password")
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				failures <- err.Error()
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				failures <- resp.Status
+			}
+		}()
+	}
+	wg.Wait()
+	close(failures)
+	for failure := range failures {
+		t.Fatalf("Failed to allocate reference: %s", failure)
+	}
+
+	code, body := do(t, server, "GET", "/api/status", "")
+	if code != http.StatusOK || strings.TrimSpace(string(body)) != `["1"]` {
+		t.Fatalf("Expected a single list, got %d: %s", code, body)
+	}
+
+	// A list that keeps changing under the allocation asks the issuer to retry
+	busy, _ := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.Repo = conflictingRepository{models.NewMemoryRepository()}
+	})
+	createList(t, busy, "")
+	req, _ := http.NewRequest("POST", busy.URL+"/api/references", nil)
+	req.SetBasicAuth("user", "password")
+	resp, err := busy.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error allocating reference: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("Expected 503 with Retry-After, got %d", resp.StatusCode)
+	}
+}
+
+// slowRepository takes a while to list the lists.
+type slowRepository struct {
+	models.StatusRepository
+}
+
+func (r slowRepository) ListStatusMeta() ([]models.StatusMeta, error) {
+	metas, err := r.StatusRepository.ListStatusMeta()
+	time.Sleep(20 * time.Millisecond)
+	return metas, err
+}
+
+// conflictingRepository loses every update against a concurrent one.
+type conflictingRepository struct {
+	models.StatusRepository
+}
+
+func (conflictingRepository) UpdateStatus(string, models.Audit, func(*status.StatusList) error) error {
+	return models.ErrConflict
+}
+
+func TestListCapacity(t *testing.T) {
+	server, signer := newTestServerWithCapacity(t, 8)
+
+	// Eight suspension references fill the first list, the ninth rolls over to a new one
+	uris := make(map[string]int)
+	for i := 0; i < 9; i++ {
+		code, body := do(t, server, "POST", "/api/references?purpose=suspension", "")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 allocating a reference, got %d: %s", code, body)
+		}
+		var response map[string]StatusListReference
+		json.Unmarshal(body, &response)
+		uris[response["status_list"].URI]++
+	}
+	first, second := testListURI("1"), testListURI("2")
+	if len(uris) != 2 || uris[first] != 8 || uris[second] != 1 {
+		t.Fatalf("Expected 8 references in %s and 1 in %s, got %v", first, second, uris)
+	}
+
+	code, body := do(t, server, "GET", "/api/status/1/meta", "")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200 getting metadata, got %d: %s", code, body)
+	}
+	var meta models.StatusMeta
+	json.Unmarshal(body, &meta)
+	if meta.Purpose != "suspension" || meta.Bits != 1 || meta.Capacity != 8 || meta.Used != 8 || !meta.Sealed || meta.SealedAt == nil || meta.CreatedAt == nil {
+		t.Fatalf("Expected a sealed suspension list of 8, got %s", body)
+	}
+
+	code, body = do(t, server, "GET", "/api/status/2/meta", "")
+	var open models.StatusMeta
+	json.Unmarshal(body, &open)
+	if code != http.StatusOK || open.Used != 1 || open.Sealed || open.SealedAt != nil {
+		t.Fatalf("Expected an open list with 1 entry, got %d: %s", code, body)
+	}
+
+	// A sealed list takes no more entries
+	if code, _ := do(t, server, "POST", "/api/status/1", ""); code != http.StatusConflict {
+		t.Fatalf("Expected 409 allocating in a sealed list, got %d", code)
+	}
+
+	// The stored purpose is the default of the credential
+	code, body = do(t, server, "GET", "/credentials/status/1", "")
+	credential, err := crypto.ParseBitstringStatusListCredential(body, signer.Public().(*ecdsa.PublicKey))
+	if err != nil {
+		t.Fatalf("Expected a credential, got %d: %s: %v", code, body, err)
+	}
+	if credential.CredentialSubject.StatusPurpose != crypto.PurposeSuspension {
+		t.Fatalf("Expected purpose suspension, got %s", credential.CredentialSubject.StatusPurpose)
+	}
+	if code, _ := do(t, server, "GET", "/credentials/status/1?purpose=revocation", ""); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a purpose other than the list's, got %d", code)
+	}
+	if code, _ := do(t, server, "GET", "/credentials/status/1?purpose=suspension", ""); code != http.StatusOK {
+		t.Fatalf("Expected 200 for the list's own purpose, got %d", code)
+	}
+
+	for query, want := range map[string]int{
+		"?purpose=expiry":            http.StatusBadRequest,
+		"?bits=2&purpose=revocation": http.StatusBadRequest,
+		"?bits=2":                    http.StatusOK,
+	} {
+		if code, body := do(t, server, "POST", "/api/status"+query, ""); code != want {
+			t.Fatalf("Expected %d creating a list with %s, got %d: %s", want, query, code, body)
+		}
+	}
+	if code, _ := do(t, server, "GET", "/api/status/999/meta", ""); code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for the metadata of an unknown list, got %d", code)
+	}
+}
+
+func TestStatusListAggregation(t *testing.T) {
+	server, signer := newTestServer(t)
+	publicKey := signer.Public().(*ecdsa.PublicKey)
+
+	var statusIds []string
+	for _, query := range []string{"", "?purpose=suspension", "?bits=2"} {
+		statusIds = append(statusIds, createList(t, server, query))
+	}
+
+	uri := testListURI
+	for query, want := range map[string][]string{
+		"":                               {uri(statusIds[0]), uri(statusIds[1]), uri(statusIds[2])},
+		"?purpose=revocation":            {uri(statusIds[0])},
+		"?purpose=message":               {uri(statusIds[2])},
+		"?issuer=http://status.test":     {uri(statusIds[0]), uri(statusIds[1]), uri(statusIds[2])},
+		"?issuer=https://elsewhere.test": {},
+	} {
+		code, body := do(t, server, "GET", "/statuslists"+query, "")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 for %q, got %d: %s", query, code, body)
+		}
+		claims, err := crypto.ParseStatusListAggregation(body, publicKey)
+		if err != nil {
+			t.Fatalf("Error parsing aggregation for %q: %v", query, err)
+		}
+		if claims.Issuer != "http://status.test" || claims.ExpiresAt == 0 {
+			t.Fatalf("Unexpected aggregation claims for %q: %+v", query, claims)
+		}
+		if fmt.Sprint(claims.StatusLists) != fmt.Sprint(want) {
+			t.Fatalf("Expected %v for %q, got %v", want, query, claims.StatusLists)
+		}
+	}
+
+	if code, _ := do(t, server, "GET", "/statuslists?purpose=bogus", ""); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an unknown purpose, got %d", code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	server, signer := newTestServerWithCapacity(t, 8)
+
+	for _, statusId := range []string{"1", "2"} {
+		if created := createList(t, server, ""); created != statusId {
+			t.Fatalf("Expected list %s to be created, got %s", statusId, created)
+		}
+	}
+
+	for _, tc := range []struct {
+		user, method, path string
+		want               int
+	}{
+		{"reader", "GET", "/api/status/1/meta", http.StatusOK},
+		{"reader", "GET", "/api/status/1/3/history", http.StatusOK},
+		{"reader", "POST", "/api/status/1", http.StatusForbidden},
+		{"reader", "PUT", "/api/status/1/3", http.StatusForbidden},
+		{"issuer", "POST", "/api/status/1", http.StatusOK},
+		{"issuer", "POST", "/api/references", http.StatusOK},
+		{"issuer", "PUT", "/api/status/1/3", http.StatusForbidden},
+		{"issuer", "GET", "/api/status/1/meta", http.StatusForbidden},
+		{"revoker", "PUT", "/api/status/1/3", http.StatusOK},
+		{"revoker", "DELETE", "/api/status/1/3", http.StatusOK},
+		{"revoker", "PATCH", "/api/status/1", http.StatusOK},
+		{"revoker", "POST", "/api/status/1", http.StatusForbidden},
+		{"revoker", "POST", "/api/status", http.StatusForbidden},
+		{"revoker", "DELETE", "/api/status/2", http.StatusForbidden},
+		{"revoker", "POST", "/api/keys/rotate", http.StatusForbidden},
+		{"scoped", "PUT", "/api/status/1/4", http.StatusOK},
+		{"scoped", "PUT", "/api/status/2/4", http.StatusForbidden},
+		{"scoped", "GET", "/api/status/2/meta", http.StatusForbidden},
+		{"scoped", "POST", "/api/status/2", http.StatusForbidden},
+		{"scopedadmin", "GET", "/api/status/1/meta", http.StatusOK},
+		{"scopedadmin", "DELETE", "/api/status/2", http.StatusForbidden},
+		{"scopedadmin", "POST", "/api/status", http.StatusForbidden},
+		{"scopedadmin", "POST", "/api/keys/rotate", http.StatusForbidden},
+	} {
+		body := ""
+		if tc.method == "PATCH" {
+			body = `[{"index": 5}]`
+		}
+		if code, data := doAs(t, server, tc.user, tc.method, tc.path, body); code != tc.want {
+			t.Fatalf("Expected %d for %s %s %s, got %d: %s", tc.want, tc.user, tc.method, tc.path, code, data)
+		}
+	}
+
+	// Scoped users only see their lists
+	code, body := doAs(t, server, "scoped", "GET", "/api/status", "")
+	if code != http.StatusOK || strings.TrimSpace(string(body)) != `["1"]` {
+		t.Fatalf("Expected only list 1 for the scoped user, got %d: %s", code, body)
+	}
+
+	// A scoped issuer gets references in its lists only and cannot create new ones
+	for {
+		code, body := doAs(t, server, "scoped", "POST", "/api/references", "")
+		if code == http.StatusConflict {
+			break
+		}
+		var reference map[string]StatusListReference
+		json.Unmarshal(body, &reference)
+		if code != http.StatusOK || reference["status_list"].URI != "http://status.test/statuslists/1" {
+			t.Fatalf("Expected a reference in list 1, got %d: %s", code, body)
+		}
+	}
+	if code, body := doAs(t, server, "issuer", "POST", "/api/references", ""); code != http.StatusOK {
+		t.Fatalf("Expected an unscoped issuer to get a reference in a new list, got %d: %s", code, body)
+	}
+
+	// Admins rotate the signing key; the previous one stays published
+	code, body = do(t, server, "POST", "/api/keys/rotate", "")
+	var rotated map[string]string
+	json.Unmarshal(body, &rotated)
+	if code != http.StatusOK || rotated["retired"] != signer.KeyID() || rotated["kid"] == signer.KeyID() {
+		t.Fatalf("Expected the key to be rotated, got %d: %s", code, body)
+	}
+	_, body = do(t, server, "GET", "/.well-known/jwks.json", "")
+	var jwks crypto.JWKS
+	json.Unmarshal(body, &jwks)
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("Expected the active and the retired key to be published, got %s", body)
+	}
+
+	// Without persistent key storage the route is disabled
+	unpersisted, _ := newTestServerWithConfig(t, func(cfg *Config) { cfg.GenerateKey = nil })
+	if code, _ := do(t, unpersisted, "POST", "/api/keys/rotate", ""); code != http.StatusNotImplemented {
+		t.Fatalf("Expected 501 rotating without a key directory, got %d", code)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	// A stand-in OAuth2 server publishing the key its access tokens are signed with
+	oauthSigner, err := crypto.GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+	oauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(crypto.NewKeyRegistry(oauthSigner, time.Hour).JWKS())
+	}))
+	defer oauth.Close()
+	tokens := auth.NewTokenVerifier(oauth.URL, "status-api", crypto.NewRemoteKeySet(oauth.URL, oauth.Client()), nil)
+
+	accessToken := func(scope string) string {
+		token, err := crypto.SignJWS(oauthSigner, "at+jwt", map[string]interface{}{
+			"iss":   oauth.URL,
+			"aud":   "status-api",
+			"sub":   "compliance-service",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": scope,
+		})
+		if err != nil {
+			t.Fatalf("Error signing access token: %v", err)
+		}
+		return token
+	}
+	send := func(server *httptest.Server, authorization, method, path string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("Authorization", authorization)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Error sending %s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Alongside Basic authentication
+	server, _ := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.Tokens = tokens
+	})
+	createList(t, server, "")
+
+	revoker := "Bearer " + accessToken("status:revoke")
+	if resp := send(server, revoker, "PUT", "/api/status/1/3"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a revoker token to set a status, got %d", resp.StatusCode)
+	}
+	if resp := send(server, revoker, "POST", "/api/status/1"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a revoker token not to allocate entries, got %d", resp.StatusCode)
+	}
+	_, body := do(t, server, "GET", "/api/status/1/3/history", "")
+	if !strings.Contains(string(body), `"actor":"compliance-service"`) {
+		t.Fatalf("Expected the token subject to be recorded as the actor, got %s", body)
+	}
+
+	resp := send(server, "Bearer "+accessToken("status:revoke")+"x", "PUT", "/api/status/1/3")
+	if resp.StatusCode != http.StatusUnauthorized || len(resp.Header.Values("WWW-Authenticate")) != 2 {
+		t.Fatalf("Expected 401 with Basic and Bearer challenges for a forged token, got %d: %v", resp.StatusCode, resp.Header.Values("WWW-Authenticate"))
+	}
+
+	// Instead of Basic authentication
+	server, _ = newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.Users = nil
+		cfg.Tokens = tokens
+	})
+	if code, _ := do(t, server, "GET", "/api/status", ""); code != http.StatusUnauthorized {
+		t.Fatalf("Expected Basic authentication to be disabled, got %d", code)
+	}
+	if resp := send(server, "Bearer "+accessToken("status:read"), "GET", "/api/status"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a reader token to list lists, got %d", resp.StatusCode)
+	}
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "Test CA")
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+	serverCert, serverKey := ca.issue(t, dir, "server", &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	spiffe, _ := url.Parse("spiffe://status.test/compliance")
+	revokerCert, revokerKey := ca.issue(t, dir, "revoker", &x509.Certificate{URIs: []*url.URL{spiffe}})
+	unknownCert, unknownKey := ca.issue(t, dir, "unknown", &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}})
+	otherCert, otherKey := newTestCA(t, "Other CA").issue(t, dir, "other", &x509.Certificate{URIs: []*url.URL{spiffe}})
+
+	users := auth.NewUserStore(auth.User{Name: spiffe.String(), Grant: auth.Grant{Roles: []auth.Role{auth.RoleRevoker}}})
+	server, _ := newTestServerWithConfig(t, func(cfg *Config) {
+		cfg.Certificates = users
+	})
+	createList(t, server, "")
+
+	tlsConfig, err := auth.ServerTLSConfig(serverCert, serverKey, caFile, auth.ClientAuthRequest)
+	if err != nil {
+		t.Fatalf("Error configuring TLS: %v", err)
+	}
+	tlsServer := httptest.NewUnstartedServer(server.Config.Handler)
+	tlsServer.TLS = tlsConfig
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	send := func(certFile, keyFile, method, path string) (int, error) {
+		clientConfig, err := auth.ClientTLSConfig(caFile, certFile, keyFile)
+		if err != nil {
+			t.Fatalf("Error configuring client TLS: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		req, _ := http.NewRequest(method, tlsServer.URL+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	for _, tc := range []struct {
+		name, cert, key, method, path string
+		want                          int
+	}{
+		{"revoker", revokerCert, revokerKey, "PUT", "/api/status/1/3", http.StatusOK},
+		{"revoker", revokerCert, revokerKey, "POST", "/api/status/1", http.StatusForbidden},
+		{"unknown identity", unknownCert, unknownKey, "PUT", "/api/status/1/3", http.StatusUnauthorized},
+		{"no certificate", "", "", "PUT", "/api/status/1/3", http.StatusUnauthorized},
+		{"no certificate", "", "", "GET", "/statuslists/1", http.StatusOK},
+	} {
+		code, err := send(tc.cert, tc.key, tc.method, tc.path)
+		if err != nil {
+			t.Fatalf("Error sending %s %s with %s: %v", tc.method, tc.path, tc.name, err)
+		}
+		if code != tc.want {
+			t.Fatalf("Expected %d for %s %s with %s, got %d", tc.want, tc.method, tc.path, tc.name, code)
+		}
+	}
+
+	// Certificates of another CA never authenticate: clients do not present them to a server
+	// asking for our CA, and the handshake fails if they do
+	if code, err := send(otherCert, otherKey, "PUT", "/api/status/1/3"); err == nil && code != http.StatusUnauthorized {
+		t.Fatalf("Expected a certificate of another CA to be rejected, got %d", code)
+	}
+
+	// Requiring client certificates closes even the public endpoints to clients without one
+	tlsConfig, err = auth.ServerTLSConfig(serverCert, serverKey, caFile, auth.ClientAuthRequire)
+	if err != nil {
+		t.Fatalf("Error configuring TLS: %v", err)
+	}
+	tlsServer.Close()
+	tlsServer = httptest.NewUnstartedServer(server.Config.Handler)
+	tlsServer.TLS = tlsConfig
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	if _, err := send("", "", "GET", "/statuslists/1"); err == nil {
+		t.Fatalf("Expected a client without certificate to be rejected")
+	}
+	if code, err := send(revokerCert, revokerKey, "GET", "/statuslists/1"); err != nil || code != http.StatusOK {
+		t.Fatalf("Expected 200 with a client certificate, got %d, %v", code, err)
+	}
+}
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue signs a certificate for template and writes it and its key to dir, returning the file names.
+func (ca *testCA) issue(t *testing.T, dir, name string, template *x509.Certificate) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	if template.ExtKeyUsage == nil {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding key: %v", err)
+	}
+	return writePEM(t, dir, name+".pem", "CERTIFICATE", der), writePEM(t, dir, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", name, err)
+	}
+	return filename
+}
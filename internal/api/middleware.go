@@ -1,35 +1,225 @@
 package api
 
 import (
-	"encoding/base64"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
 )
 
-func BasicAuth(next http.Handler) http.Handler {
+// Principal is the authenticated caller of a request and what it may access.
+type Principal struct {
+	Name string
+	auth.Grant
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal authenticated for a request.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+func withPrincipal(r *http.Request, principal Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+}
+
+// Authenticate authenticates every request with the Basic credentials of a user of
+// Config.Users, a bearer token verified by Config.Tokens or, without an Authorization
+// header, a verified client certificate of a user of Config.Certificates, whichever are
+// configured.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if auth == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		var principal Principal
+		var ok bool
+		switch scheme := authScheme(r); {
+		case scheme == "" && s.config.Certificates != nil && clientCertificate(r) != nil:
+			principal, ok = s.certificatePrincipal(w, r)
+		case scheme == "":
+			s.unauthorized(w, "Authorization header required")
+			return
+		case scheme == "basic" && s.config.Users != nil:
+			principal, ok = s.basicPrincipal(w, r)
+		case scheme == "bearer" && s.config.Tokens != nil:
+			principal, ok = s.bearerPrincipal(w, r)
+		default:
+			s.unauthorized(w, "Unsupported authorization scheme")
 			return
 		}
 
-		const prefix = "Basic "
-		if !strings.HasPrefix(auth, prefix) {
-			http.Error(w, "Authorization header format must be Basic {base64}", http.StatusUnauthorized)
-			return
+		if ok {
+			next.ServeHTTP(w, withPrincipal(r, principal))
 		}
+	})
+}
 
-		encodedCredentials := strings.TrimPrefix(auth, prefix)
-		// Replace "username:password" with actual credentials or a verification function
-		expectedCredentials := "user:pass"
-		expectedEncodedCredentials := base64.StdEncoding.EncodeToString([]byte(expectedCredentials))
+// basicPrincipal returns the user of the request's Basic credentials, answering the request if there is none.
+func (s *Server) basicPrincipal(w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		s.unauthorized(w, "Authorization header format must be Basic {base64}")
+		return Principal{}, false
+	}
 
-		if encodedCredentials != expectedEncodedCredentials {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-			return
-		}
+	user, err := s.config.Users.Authenticate(username, password)
+	if err == auth.ErrInvalidCredentials {
+		s.unauthorized(w, "Invalid credentials")
+		return Principal{}, false
+	}
+	if err != nil {
+		log.Printf("Failed to authenticate %s: %v", username, err)
+		http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+		return Principal{}, false
+	}
+
+	return Principal{Name: user.Name, Grant: user.Grant}, true
+}
+
+// bearerPrincipal returns the client of the request's bearer token, answering the request if there is none.
+func (s *Server) bearerPrincipal(w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	header := r.Header.Get("Authorization")
+	token := ""
+	if i := strings.Index(header, " "); i >= 0 {
+		token = strings.TrimSpace(header[i+1:])
+	}
+	if authScheme(r) != "bearer" || token == "" {
+		s.unauthorized(w, "Authorization header format must be Bearer {token}")
+		return Principal{}, false
+	}
+
+	name, grant, err := s.config.Tokens.Verify(r.Context(), token)
+	if err != nil {
+		log.Printf("Rejected bearer token: %v", err)
+		s.unauthorized(w, "Invalid token")
+		return Principal{}, false
+	}
+
+	return Principal{Name: name, Grant: grant}, true
+}
+
+// certificatePrincipal returns the user named by the identity of the request's client certificate, answering the request if there is none.
+func (s *Server) certificatePrincipal(w http.ResponseWriter, r *http.Request) (Principal, bool) {
+	identity := auth.CertificateIdentity(clientCertificate(r))
+
+	user, err := s.config.Certificates.Lookup(identity)
+	if err == auth.ErrInvalidCredentials {
+		s.unauthorized(w, "Unknown client certificate")
+		return Principal{}, false
+	}
+	if err != nil {
+		log.Printf("Failed to authenticate %s: %v", identity, err)
+		http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+		return Principal{}, false
+	}
+
+	return Principal{Name: user.Name, Grant: user.Grant}, true
+}
+
+// clientCertificate returns the client certificate the TLS handshake verified, if any.
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// authScheme returns the lower case scheme of the request's Authorization header, or "" without one.
+func authScheme(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return ""
+	}
+	if i := strings.Index(header, " "); i >= 0 {
+		header = header[:i]
+	}
+	return strings.ToLower(header)
+}
+
+// RequireRole lets only principals with role through. On routes of a single list the role
+// must also apply to that list.
+func (s *Server) RequireRole(role auth.Role) mux.MiddlewareFunc {
+	return s.requireGrant(role, false)
+}
+
+// RequireUnrestrictedRole lets only principals with role for every list through, for
+// routes acting beyond single lists such as creating lists or rotating the signing key.
+func (s *Server) RequireUnrestrictedRole(role auth.Role) mux.MiddlewareFunc {
+	return s.requireGrant(role, true)
+}
 
-		next.ServeHTTP(w, r)
+func (s *Server) requireGrant(role auth.Role, unrestricted bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				s.unauthorized(w, "Unauthenticated")
+				return
+			}
+
+			statusId, scoped := mux.Vars(r)["statusId"]
+			if !principal.HasRole(role) || (scoped && !principal.CanAccess(statusId)) || (unrestricted && !principal.Unrestricted()) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// unauthorized answers 401 with a challenge for every configured way to authenticate.
+func (s *Server) unauthorized(w http.ResponseWriter, message string) {
+	if s.config.Users != nil {
+		w.Header().Add("WWW-Authenticate", `Basic realm="status", charset="UTF-8"`)
+	}
+	if s.config.Tokens != nil {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="status"`)
+	}
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// PublicCache lets verifiers and shared caches keep successful responses of the public
+// endpoints for Config.TokenTTL, unless the handler set its own Cache-Control, and lets
+// browsers read them from any origin. Errors are not cached, so a new list is seen at once.
+func (s *Server) PublicCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		next.ServeHTTP(&cacheWriter{ResponseWriter: w, ttl: s.config.TokenTTL}, r)
 	})
 }
+
+// cacheWriter adds the Cache-Control header once the status of the response is known.
+type cacheWriter struct {
+	http.ResponseWriter
+	ttl         time.Duration
+	wroteHeader bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		header := w.Header()
+		if header.Get("Cache-Control") == "" {
+			if code == http.StatusOK {
+				header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(w.ttl.Seconds())))
+			} else {
+				header.Set("Cache-Control", "no-store")
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/auth"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/pkg/models"
+)
+
+// StatusListReference points a credential at its entry in a status list, in the form of
+// the IETF Token Status List "status" claim.
+type StatusListReference struct {
+	Index int    `json:"idx"`
+	URI   string `json:"uri"`
+}
+
+// errWrongList rejects a list whose bits or purpose differ from the requested ones.
+var errWrongList = errors.New("status list has different bits or purpose")
+
+// errNoList is returned when no list a grant restricted to some lists can access has unallocated entries.
+var errNoList = errors.New("no accessible status list has unallocated entries")
+
+// listKind identifies the lists a reference can be allocated in.
+type listKind struct {
+	bits    int
+	purpose string
+}
+
+// CreateReference allocates an entry in any unsealed list with the requested bits and
+// purpose, creating a new list when all are sealed, and returns the reference for the
+// issuer to embed in a credential. Issuers restricted to some lists only get entries in
+// those and answer 409 Conflict once they are full, as they may not create lists.
+func (s *Server) CreateReference(w http.ResponseWriter, r *http.Request) {
+	bits, purpose, ok := listParameters(w, r)
+	if !ok {
+		return
+	}
+
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthenticated", http.StatusUnauthorized)
+		return
+	}
+
+	statusId, index, err := s.allocateReference(models.Audit{Actor: principal.Name}, listKind{bits: bits, purpose: purpose}, principal.Grant)
+	switch {
+	case err == errNoList:
+		http.Error(w, "No accessible status list has unallocated entries", http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrConflict):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Status list is busy, retry later", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("Failed to allocate status reference: %v", err)
+		http.Error(w, "Failed to allocate status reference", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]StatusListReference{
+		"status_list": {Index: index, URI: s.statusListURI(statusId)},
+	})
+}
+
+// allocateReference allocates an entry in the current list of kind, then in any other
+// unsealed list of kind, then in a new list, among the lists grant can access.
+func (s *Server) allocateReference(audit models.Audit, kind listKind, grant auth.Grant) (string, int, error) {
+	s.currentLists.Lock()
+	current := s.currentLists.ids[kind]
+	s.currentLists.Unlock()
+
+	if current != "" && grant.CanAccess(current) {
+		if index, ok, err := s.allocateIn(current, audit, kind); ok || err != nil {
+			return current, index, err
+		}
+	}
+
+	// Requests that find the current list sealed search and create one at a time, so a
+	// request that waited finds the list created meanwhile instead of creating another
+	lock := s.kindLock(kind)
+	lock.Lock()
+	defer lock.Unlock()
+
+	metas, err := s.config.Repo.ListStatusMeta()
+	if err != nil {
+		return "", 0, err
+	}
+	for i := range metas {
+		meta := &metas[i]
+		if meta.StatusId == current || meta.Sealed || meta.Bits != kind.bits || listPurpose(meta) != kind.purpose || !grant.CanAccess(meta.StatusId) {
+			continue
+		}
+		index, ok, err := s.allocateIn(meta.StatusId, audit, kind)
+		if err != nil {
+			return "", 0, err
+		}
+		if ok {
+			s.setCurrentList(kind, meta.StatusId)
+			return meta.StatusId, index, nil
+		}
+	}
+
+	if len(grant.Lists) > 0 {
+		return "", 0, errNoList
+	}
+
+	list, err := status.NewStatusListWithSize(kind.bits, s.config.ListCapacity)
+	if err != nil {
+		return "", 0, err
+	}
+	index, err := list.AddStatus(false)
+	if err != nil {
+		return "", 0, err
+	}
+	statusId, err := s.config.Repo.CreateNewStatus(list, kind.purpose)
+	if err != nil {
+		return "", 0, err
+	}
+	log.Printf("Created status list %s for new %s references", statusId, kind.purpose)
+	s.setCurrentList(kind, statusId)
+	return statusId, index, nil
+}
+
+// allocateIn allocates an entry in a list of kind, reporting false if the list is sealed, gone or of another kind.
+func (s *Server) allocateIn(statusId string, audit models.Audit, kind listKind) (int, bool, error) {
+	// The purpose cannot change, so it is checked once; the bits are checked again with the list
+	meta, err := s.config.Repo.GetStatusMeta(statusId)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if listPurpose(meta) != kind.purpose {
+		return 0, false, nil
+	}
+
+	var index int
+	err = s.config.Repo.UpdateStatus(statusId, audit, func(list *status.StatusList) error {
+		if list.Bits() != kind.bits {
+			return errWrongList
+		}
+		var err error
+		index, err = list.AddStatus(false)
+		return err
+	})
+	switch {
+	case err == nil:
+		return index, true, nil
+	case errors.Is(err, status.ErrListFull), errors.Is(err, models.ErrNotFound), err == errWrongList:
+		return 0, false, nil
+	}
+	return 0, false, err
+}
+
+// kindLock returns the lock serializing the search for and creation of lists of kind.
+func (s *Server) kindLock(kind listKind) *sync.Mutex {
+	s.currentLists.Lock()
+	defer s.currentLists.Unlock()
+
+	lock, ok := s.currentLists.locks[kind]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.currentLists.locks[kind] = lock
+	}
+	return lock
+}
+
+func (s *Server) setCurrentList(kind listKind, statusId string) {
+	s.currentLists.Lock()
+	s.currentLists.ids[kind] = statusId
+	s.currentLists.Unlock()
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	stdcrypto "crypto"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenLeeway tolerates clock skew between the OAuth2 server and us.
+const tokenLeeway = 30 * time.Second
+
+// DefaultScopes maps the OAuth2 scopes a token may carry to the roles they grant.
+var DefaultScopes = map[string]Role{
+	"status:read":   RoleReader,
+	"status:issue":  RoleIssuer,
+	"status:revoke": RoleRevoker,
+	"status:admin":  RoleAdmin,
+}
+
+// ListsClaim is the token claim restricting the granted roles to the lists with these IDs,
+// like the lists of a user.
+const ListsClaim = "status_lists"
+
+// KeySource looks up the key that signed a token by its kid, e.g. a crypto.RemoteKeySet
+// fetched from the OAuth2 server or StaticKeys.
+type KeySource interface {
+	VerificationKey(ctx context.Context, kid string) (stdcrypto.PublicKey, error)
+}
+
+// StaticKeys is a KeySource looking keys up without fetching them, e.g. the
+// VerificationKey method of a crypto.JWKS read from a file.
+type StaticKeys func(kid string) (stdcrypto.PublicKey, error)
+
+// VerificationKey returns the key published under kid.
+func (f StaticKeys) VerificationKey(_ context.Context, kid string) (stdcrypto.PublicKey, error) {
+	return f(kid)
+}
+
+// TokenVerifier validates OAuth2 bearer JWTs, e.g. client credentials access tokens, and
+// maps their scopes to roles.
+type TokenVerifier struct {
+	issuer   string
+	audience string
+	keys     KeySource
+	scopes   map[string]Role
+}
+
+// NewTokenVerifier creates a TokenVerifier accepting tokens signed by keys with issuer as
+// "iss" and, unless empty, audience in "aud". A nil scopes uses DefaultScopes.
+func NewTokenVerifier(issuer, audience string, keys KeySource, scopes map[string]Role) *TokenVerifier {
+	if scopes == nil {
+		scopes = DefaultScopes
+	}
+	return &TokenVerifier{issuer: issuer, audience: audience, keys: keys, scopes: scopes}
+}
+
+// Verify validates a token and returns the client it was issued to ("sub", or "client_id"
+// without one) and what its scopes grant. Tokens without known scopes grant nothing.
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (string, Grant, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"ES256", "RS256", "PS256"}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	}
+	if v.audience != "" {
+		options = append(options, jwt.WithAudience(v.audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid")
+		}
+		return v.keys.VerificationKey(ctx, kid)
+	}, options...)
+	if err != nil {
+		return "", Grant{}, fmt.Errorf("invalid token: %v", err)
+	}
+
+	name, _ := claims["sub"].(string)
+	if name == "" {
+		name, _ = claims["client_id"].(string)
+	}
+	if name == "" {
+		return "", Grant{}, errors.New("invalid token: token has neither sub nor client_id")
+	}
+
+	var grant Grant
+	for _, scope := range tokenScopes(claims) {
+		if role, ok := v.scopes[scope]; ok {
+			grant.Roles = append(grant.Roles, role)
+		}
+	}
+	if lists, ok := claims[ListsClaim].([]interface{}); ok {
+		for _, id := range lists {
+			if id, ok := id.(string); ok {
+				grant.Lists = append(grant.Lists, id)
+			}
+		}
+		// An empty restriction must not lift it
+		if len(grant.Lists) == 0 {
+			grant.Roles = nil
+		}
+	}
+
+	return name, grant, nil
+}
+
+// tokenScopes returns the scopes of a token, from the space separated "scope" claim of
+// RFC 9068 or the "scp" array some servers use instead.
+func tokenScopes(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+
+	var scopes []string
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, scope := range scp {
+			if scope, ok := scope.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// ParseScopes parses a comma separated list of scope=role mappings, e.g.
+// "status.read=reader,status.write=revoker".
+func ParseScopes(s string) (map[string]Role, error) {
+	scopes := make(map[string]Role)
+	for _, mapping := range strings.Split(s, ",") {
+		i := strings.Index(mapping, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid scope mapping %q, use scope=role", mapping)
+		}
+		roles, err := ParseRoles(mapping[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		scopes[strings.TrimSpace(mapping[:i])] = roles[0]
+	}
+	return scopes, nil
+}
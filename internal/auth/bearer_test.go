@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
+)
+
+func TestTokenVerifier(t *testing.T) {
+	signer, err := crypto.GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+
+	keys := crypto.NewKeyRegistry(signer, time.Hour).JWKS()
+	keys.Keys = append(keys.Keys, crypto.JWK{
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		Kid: "rsa",
+	})
+	verifier := NewTokenVerifier("https://oauth.test", "status-api", StaticKeys(keys.VerificationKey), nil)
+
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"iss":   "https://oauth.test",
+			"aud":   "status-api",
+			"sub":   "issuer-service",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "openid status:issue status:revoke",
+		}
+		for name, value := range extra {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+	es256 := func(claims jwt.MapClaims) string {
+		token, err := crypto.SignJWS(signer, "at+jwt", claims)
+		if err != nil {
+			t.Fatalf("Error signing token: %v", err)
+		}
+		return token
+	}
+	rs256 := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "rsa"
+		signed, err := token.SignedString(rsaKey)
+		if err != nil {
+			t.Fatalf("Error signing token: %v", err)
+		}
+		return signed
+	}
+
+	ctx := context.Background()
+	for _, token := range []string{es256(claims(nil)), rs256(claims(nil))} {
+		name, grant, err := verifier.Verify(ctx, token)
+		if err != nil {
+			t.Fatalf("Error verifying token: %v", err)
+		}
+		if name != "issuer-service" || !grant.HasRole(RoleIssuer) || !grant.HasRole(RoleRevoker) || grant.HasRole(RoleReader) || len(grant.Lists) != 0 {
+			t.Fatalf("Unexpected principal %s with %+v", name, grant)
+		}
+	}
+
+	// The "scp" array, "client_id" and the lists claim
+	name, grant, err := verifier.Verify(ctx, es256(claims(jwt.MapClaims{
+		"sub":       nil,
+		"scope":     nil,
+		"client_id": "reader-service",
+		"scp":       []string{"status:read"},
+		ListsClaim:  []string{"1", "2"},
+	})))
+	if err != nil {
+		t.Fatalf("Error verifying token: %v", err)
+	}
+	if name != "reader-service" || !grant.HasRole(RoleReader) || grant.HasRole(RoleIssuer) || !grant.CanAccess("2") || grant.CanAccess("3") {
+		t.Fatalf("Unexpected principal %s with %+v", name, grant)
+	}
+
+	// An empty list restriction grants nothing rather than everything
+	if _, grant, err := verifier.Verify(ctx, es256(claims(jwt.MapClaims{ListsClaim: []string{}}))); err != nil || len(grant.Roles) != 0 {
+		t.Fatalf("Expected no roles for an empty list restriction, got %+v, %v", grant, err)
+	}
+
+	other, _ := crypto.GenerateMemorySigner()
+	unknownKey, _ := crypto.SignJWS(other, "at+jwt", claims(nil))
+
+	for name, token := range map[string]string{
+		"wrong issuer":   es256(claims(jwt.MapClaims{"iss": "https://elsewhere.test"})),
+		"wrong audience": es256(claims(jwt.MapClaims{"aud": "another-api"})),
+		"expired":        es256(claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"no expiry":      es256(claims(jwt.MapClaims{"exp": nil})),
+		"no subject":     es256(claims(jwt.MapClaims{"sub": nil})),
+		"unknown key":    unknownKey,
+		"malformed":      "not.a.token",
+	} {
+		if _, _, err := verifier.Verify(ctx, token); err == nil {
+			t.Fatalf("Expected a token with %s to be rejected", name)
+		}
+	}
+
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("secret"))
+	if _, _, err := verifier.Verify(ctx, hmac); err == nil {
+		t.Fatalf("Expected a symmetrically signed token to be rejected")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("status.read=reader, status.write=revoker")
+	if err != nil {
+		t.Fatalf("Error parsing scopes: %v", err)
+	}
+	if len(scopes) != 2 || scopes["status.read"] != RoleReader || scopes["status.write"] != RoleRevoker {
+		t.Fatalf("Unexpected scopes %v", scopes)
+	}
+
+	for _, invalid := range []string{"status.read", "=reader", "status.read=root"} {
+		if _, err := ParseScopes(invalid); err == nil {
+			t.Fatalf("Expected %q to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role grants a user access to a group of management endpoints.
+type Role string
+
+const (
+	// RoleReader may read the metadata, history and IDs of lists.
+	RoleReader Role = "reader"
+	// RoleIssuer may allocate indexes for new credentials.
+	RoleIssuer Role = "issuer"
+	// RoleRevoker may set and reset statuses.
+	RoleRevoker Role = "revoker"
+	// RoleAdmin may do everything, including creating and deleting lists and rotating keys.
+	RoleAdmin Role = "admin"
+)
+
+// Roles are all roles, from the least to the most privileged.
+var Roles = []Role{RoleReader, RoleIssuer, RoleRevoker, RoleAdmin}
+
+// ParseRoles parses a comma separated list of roles.
+func ParseRoles(s string) ([]Role, error) {
+	var roles []Role
+	for _, name := range strings.Split(s, ",") {
+		role := Role(strings.TrimSpace(name))
+		if !role.valid() {
+			return nil, fmt.Errorf("unknown role %q, use reader, issuer, revoker or admin", name)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (r Role) valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant is the access of a user: its roles, restricted to the lists with the IDs in Lists
+// if there are any.
+type Grant struct {
+	Roles []Role   `json:"roles"`
+	Lists []string `json:"lists,omitempty"`
+}
+
+// HasRole reports whether the grant includes role. Admins have every role.
+func (g Grant) HasRole(role Role) bool {
+	for _, r := range g.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Unrestricted reports whether the grant's roles apply to every list, including lists
+// not created yet.
+func (g Grant) Unrestricted() bool {
+	return len(g.Lists) == 0
+}
+
+// CanAccess reports whether the grant's roles apply to the list. A grant without lists
+// applies to every list.
+func (g Grant) CanAccess(statusId string) bool {
+	if len(g.Lists) == 0 {
+		return true
+	}
+	for _, id := range g.Lists {
+		if id == statusId {
+			return true
+		}
+	}
+	return false
+}
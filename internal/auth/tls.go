@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ClientAuth is how a TLS server treats client certificates.
+type ClientAuth string
+
+const (
+	// ClientAuthNone does not ask for client certificates.
+	ClientAuthNone ClientAuth = "none"
+	// ClientAuthRequest verifies a client certificate if the client sends one, so clients
+	// may also authenticate otherwise.
+	ClientAuthRequest ClientAuth = "request"
+	// ClientAuthRequire rejects connections without a valid client certificate.
+	ClientAuthRequire ClientAuth = "require"
+)
+
+// ServerTLSConfig loads the server certificate and key and, unless clientAuth is
+// ClientAuthNone, the CA bundle client certificates are verified against.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string, clientAuth ClientAuth) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch clientAuth {
+	case ClientAuthNone:
+		return config, nil
+	case ClientAuthRequest:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth %q, use none, request or require", clientAuth)
+	}
+
+	if clientCAFile == "" {
+		return nil, errors.New("verifying client certificates needs a client CA bundle")
+	}
+	config.ClientCAs, err = loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ClientTLSConfig trusts the server certificates issued by the CA bundle at caFile, or the
+// system roots without one, and presents the client certificate at certFile, if any.
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// CertificateIdentity returns the identity a client certificate stands for: its first URI
+// SAN (e.g. a SPIFFE ID), DNS SAN or email SAN, in this order, or else the subject's common
+// name. It is the name of the user whose roles the client gets.
+func CertificateIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return cert.Subject.CommonName
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in CA bundle %s", filename)
+	}
+	return pool, nil
+}
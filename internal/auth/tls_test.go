@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestCertificateIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/issuer")
+	for want, cert := range map[string]*x509.Certificate{
+		"spiffe://example.org/issuer": {URIs: []*url.URL{spiffe}, DNSNames: []string{"issuer.internal"}, Subject: pkix.Name{CommonName: "issuer"}},
+		"issuer.internal":             {DNSNames: []string{"issuer.internal"}, EmailAddresses: []string{"issuer@example.org"}},
+		"issuer@example.org":          {EmailAddresses: []string{"issuer@example.org"}, Subject: pkix.Name{CommonName: "issuer"}},
+		"issuer":                      {Subject: pkix.Name{CommonName: "issuer", Organization: []string{"Example"}}},
+	} {
+		if got := CertificateIdentity(cert); got != want {
+			t.Fatalf("Expected identity %s, got %s", want, got)
+		}
+	}
+}
+
+func TestServerTLSConfigErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := ServerTLSConfig(missing, missing, "", ClientAuthNone); err == nil {
+		t.Fatalf("Expected a missing certificate to be rejected")
+	}
+	if _, err := ClientTLSConfig(missing, "", ""); err == nil {
+		t.Fatalf("Expected a missing CA bundle to be rejected")
+	}
+}
+
+func TestCertificateUser(t *testing.T) {
+	store := NewUserStore()
+	if err := store.Add("spiffe://example.org/issuer", ""); err != nil {
+		t.Fatalf("Error adding certificate user: %v", err)
+	}
+
+	if user, err := store.Lookup("spiffe://example.org/issuer"); err != nil || user.Name != "spiffe://example.org/issuer" {
+		t.Fatalf("Expected the certificate user, got %v, %v", user, err)
+	}
+	if _, err := store.Lookup("spiffe://example.org/other"); err != ErrInvalidCredentials {
+		t.Fatalf("Expected ErrInvalidCredentials for an unknown identity, got %v", err)
+	}
+	// Without a password the user cannot use one
+	if _, err := store.Authenticate("spiffe://example.org/issuer", ""); err != ErrInvalidCredentials {
+		t.Fatalf("Expected a certificate user to have no password, got %v", err)
+	}
+
+	store.SetDisabled("spiffe://example.org/issuer", true)
+	if _, err := store.Lookup("spiffe://example.org/issuer"); err != ErrInvalidCredentials {
+		t.Fatalf("Expected a disabled user to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+// Package auth stores the credentials of API users.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown user, a wrong password or a disabled user alike.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserExists is returned when adding a user whose name is taken.
+	ErrUserExists = errors.New("user already exists")
+	// ErrUnknownUser is returned when changing a user that does not exist.
+	ErrUnknownUser = errors.New("unknown user")
+)
+
+// MaxPasswordLength is the longest password, in bytes, bcrypt hashes without truncating it.
+const MaxPasswordLength = 72
+
+// MinPasswordLength is the length in bytes of the shortest password accepted for a user.
+const MinPasswordLength = 8
+
+// dummyHash is compared against when a user is unknown, so the response time does not
+// tell whether a user name exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password of any user"), bcrypt.DefaultCost)
+
+// User is an API user. Only the bcrypt hash of the password is stored; users without
+// one authenticate with a client certificate for their name only. A user has no access
+// until granted roles.
+type User struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+	Disabled     bool   `json:"disabled,omitempty"`
+	Grant
+}
+
+type usersFile struct {
+	Users []User `json:"users"`
+}
+
+// UserStore holds the API users, optionally backed by a JSON file. A store opened from a
+// file picks up changes made to it, e.g. by the users subcommand, without a restart.
+// It is safe for concurrent use.
+type UserStore struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	users   map[string]User
+}
+
+// NewUserStore creates an in-memory UserStore holding users.
+func NewUserStore(users ...User) *UserStore {
+	s := &UserStore{users: make(map[string]User, len(users))}
+	for _, user := range users {
+		s.users[user.Name] = user
+	}
+	return s
+}
+
+// OpenUserStore loads the users from the JSON file at path. A missing file is an empty
+// store that Save creates.
+func OpenUserStore(path string) (*UserStore, error) {
+	s := &UserStore{path: path, users: make(map[string]User)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Authenticate checks a user's password in constant time and returns the user. Unknown
+// users, wrong passwords, users without a password and disabled users all return ErrInvalidCredentials.
+func (s *UserStore) Authenticate(name, password string) (*User, error) {
+	s.mu.Lock()
+	if err := s.reload(); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	user, ok := s.users[name]
+	s.mu.Unlock()
+
+	// Users without a password are compared against the dummy hash too, so they take as long to reject
+	known := ok && user.PasswordHash != ""
+	hash := dummyHash
+	if known {
+		hash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known || user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// Lookup returns the enabled user with name, e.g. the identity of a client certificate.
+// Unknown and disabled users return ErrInvalidCredentials.
+func (s *UserStore) Lookup(name string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	user, ok := s.users[name]
+	if !ok || user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// Add creates an enabled user. An empty password creates a user that can only
+// authenticate with a client certificate.
+func (s *UserStore) Add(name, password string) error {
+	if name == "" {
+		return errors.New("user name must not be empty")
+	}
+	hash := ""
+	if password != "" {
+		var err error
+		hash, err = HashPassword(password)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[name]; ok {
+		return ErrUserExists
+	}
+	s.users[name] = User{Name: name, PasswordHash: hash}
+	return nil
+}
+
+// SetPassword replaces the password of a user.
+func (s *UserStore) SetPassword(name, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.update(name, func(user *User) {
+		user.PasswordHash = hash
+	})
+}
+
+// SetDisabled disables or re-enables a user. Disabled users cannot authenticate.
+func (s *UserStore) SetDisabled(name string, disabled bool) error {
+	return s.update(name, func(user *User) {
+		user.Disabled = disabled
+	})
+}
+
+// SetRoles replaces the roles of a user.
+func (s *UserStore) SetRoles(name string, roles []Role) error {
+	return s.update(name, func(user *User) {
+		user.Roles = roles
+	})
+}
+
+// SetLists restricts the roles of a user to the lists with the given IDs. No IDs lift the restriction.
+func (s *UserStore) SetLists(name string, statusIds []string) error {
+	return s.update(name, func(user *User) {
+		user.Lists = statusIds
+	})
+}
+
+// Users returns all users ordered by name.
+func (s *UserStore) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// Save writes the users to the store's file, readable by its owner only. The file is
+// replaced atomically, so a running server never reads a partial file.
+func (s *UserStore) Save() error {
+	if s.path == "" {
+		return errors.New("user store has no file")
+	}
+
+	data, err := json.MarshalIndent(usersFile{Users: s.Users()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write users: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write users: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write users: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write users: %v", err)
+	}
+	return nil
+}
+
+// HashPassword returns the bcrypt hash of a password of MinPasswordLength to
+// MaxPasswordLength bytes.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must have at least %d bytes", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return "", fmt.Errorf("password must have at most %d bytes", MaxPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (s *UserStore) update(name string, fn func(*User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[name]
+	if !ok {
+		return ErrUnknownUser
+	}
+	fn(&user)
+	s.users[name] = user
+	return nil
+}
+
+// reload reads the file again if it changed since it was loaded. s.mu must be held.
+func (s *UserStore) reload() error {
+	if s.path == "" {
+		return nil
+	}
+	info, err := os.Stat(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read users: %v", err)
+	}
+	if err == nil && info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	return s.load()
+}
+
+// load replaces the users with the contents of the file. s.mu must be held.
+func (s *UserStore) load() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.users = make(map[string]User)
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read users: %v", err)
+	}
+
+	var file usersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid users file %s: %v", s.path, err)
+	}
+
+	users := make(map[string]User, len(file.Users))
+	for _, user := range file.Users {
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); user.PasswordHash != "" && err != nil {
+			return fmt.Errorf("invalid password hash of user %s: %v", user.Name, err)
+		}
+		for _, role := range user.Roles {
+			if !role.valid() {
+				return fmt.Errorf("unknown role %q of user %s", role, user.Name)
+			}
+		}
+		users[user.Name] = user
+	}
+
+	s.users = users
+	s.modTime = info.ModTime()
+	return nil
+}
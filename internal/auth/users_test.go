@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	store, err := OpenUserStore(path)
+	if err != nil {
+		t.Fatalf("Error opening missing users file: %v", err)
+	}
+
+	if err := store.Add("alice", "short"); err == nil {
+		t.Fatalf("Expected a short password to be rejected")
+	}
+	if err := store.Add("alice", strings.Repeat("x", MaxPasswordLength+1)); err == nil {
+		t.Fatalf("Expected a password longer than bcrypt hashes to be rejected")
+	}
+	if err := store.Add("alice", "correct horse"); err != nil {
+		t.Fatalf("Error adding user: %v", err)
+	}
+	if err := store.Add("alice", "battery staple"); err != ErrUserExists {
+		t.Fatalf("Expected ErrUserExists, got %v", err)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatalf("Error saving users: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Error reading users file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the users file to be private, got %v", info.Mode().Perm())
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), "correct horse") {
+		t.Fatalf("Expected only the password hash to be stored")
+	}
+
+	// A second store, like the running server, reads the file
+	server, err := OpenUserStore(path)
+	if err != nil {
+		t.Fatalf("Error opening users file: %v", err)
+	}
+	if user, err := server.Authenticate("alice", "correct horse"); err != nil || user.Name != "alice" {
+		t.Fatalf("Expected alice to authenticate, got %v, %v", user, err)
+	}
+	for _, credentials := range [][2]string{{"alice", "wrong horse"}, {"bob", "correct horse"}, {"", ""}} {
+		if _, err := server.Authenticate(credentials[0], credentials[1]); err != ErrInvalidCredentials {
+			t.Fatalf("Expected ErrInvalidCredentials for %v, got %v", credentials, err)
+		}
+	}
+
+	// Rotating the password and disabling the user take effect without reopening
+	if err := store.SetPassword("alice", "battery staple"); err != nil {
+		t.Fatalf("Error setting password: %v", err)
+	}
+	saveLater(t, store, path)
+	if _, err := server.Authenticate("alice", "correct horse"); err != ErrInvalidCredentials {
+		t.Fatalf("Expected the old password to be rejected, got %v", err)
+	}
+	if _, err := server.Authenticate("alice", "battery staple"); err != nil {
+		t.Fatalf("Expected the new password to authenticate, got %v", err)
+	}
+
+	if err := store.SetDisabled("alice", true); err != nil {
+		t.Fatalf("Error disabling user: %v", err)
+	}
+	saveLater(t, store, path)
+	if _, err := server.Authenticate("alice", "battery staple"); err != ErrInvalidCredentials {
+		t.Fatalf("Expected a disabled user to be rejected, got %v", err)
+	}
+
+	if err := store.SetDisabled("bob", true); err != ErrUnknownUser {
+		t.Fatalf("Expected ErrUnknownUser, got %v", err)
+	}
+}
+
+func TestOpenUserStoreRejectsInvalidHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := ioutil.WriteFile(path, []byte(`{"users": [{"name": "alice", "passwordHash": "plaintext"}]}`), 0600); err != nil {
+		t.Fatalf("Error writing users file: %v", err)
+	}
+	if _, err := OpenUserStore(path); err == nil {
+		t.Fatalf("Expected a user without a bcrypt hash to be rejected")
+	}
+}
+
+// saveLater saves the store with a modification time after the previous save, as
+// file systems may not tell apart writes within the same tick.
+func saveLater(t *testing.T, store *UserStore, path string) {
+	info, _ := os.Stat(path)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Error saving users: %v", err)
+	}
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Error touching users file: %v", err)
+	}
+}
+
+func TestGrant(t *testing.T) {
+	roles, err := ParseRoles("issuer, revoker")
+	if err != nil {
+		t.Fatalf("Error parsing roles: %v", err)
+	}
+	grant := Grant{Roles: roles, Lists: []string{"1"}}
+	if !grant.HasRole(RoleIssuer) || !grant.HasRole(RoleRevoker) || grant.HasRole(RoleReader) || grant.HasRole(RoleAdmin) {
+		t.Fatalf("Unexpected roles of %+v", grant)
+	}
+	if !grant.CanAccess("1") || grant.CanAccess("2") {
+		t.Fatalf("Expected %+v to access list 1 only", grant)
+	}
+
+	admin := Grant{Roles: []Role{RoleAdmin}}
+	for _, role := range Roles {
+		if !admin.HasRole(role) {
+			t.Fatalf("Expected admins to have role %s", role)
+		}
+	}
+	if !admin.CanAccess("2") {
+		t.Fatalf("Expected a grant without lists to access every list")
+	}
+	if (Grant{}).HasRole(RoleReader) {
+		t.Fatalf("Expected a user without roles to have no access")
+	}
+
+	if _, err := ParseRoles("root"); err == nil {
+		t.Fatalf("Expected an unknown role to be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// AggregationJWTType is the JOSE "typ" header of a signed status list aggregation.
+	AggregationJWTType = "statuslist-aggregation+jwt"
+	// AggregationContentType is the media type of a signed status list aggregation response.
+	AggregationContentType = "application/statuslist-aggregation+jwt"
+)
+
+// StatusListAggregationClaims represents the claims of a signed IETF Status List Aggregation:
+// the URIs of the status lists an issuer publishes.
+type StatusListAggregationClaims struct {
+	Issuer      string   `json:"iss"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	TTL         int64    `json:"ttl,omitempty"`
+	StatusLists []string `json:"status_lists"`
+}
+
+// ParseStatusListAggregation validates the signature, type and expiry of a signed status list aggregation and returns its claims
+func ParseStatusListAggregation(body []byte, publicKey *ecdsa.PublicKey) (*StatusListAggregationClaims, error) {
+	token, err := jwt.Parse(strings.TrimSpace(string(body)), func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != AggregationJWTType {
+			return nil, fmt.Errorf("unexpected token type: %v", token.Header["typ"])
+		}
+		return publicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse status list aggregation: %v", err)
+	}
+
+	claimsJSON, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims StatusListAggregationClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("invalid status list aggregation claims: %v", err)
+	}
+
+	if claims.StatusLists == nil {
+		return nil, errors.New("status list aggregation has no status_lists claim")
+	}
+
+	return &claims, nil
+}
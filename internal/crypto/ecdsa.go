@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -44,31 +45,78 @@ func GenerateECDSAKey(filename string) error {
 	return nil
 }
 
-// ReadPEMKeyAndSign reads the PEM key from the specified file, signs the message, and returns the signature in Base64URL format.
-func ReadPEMKeyAndSign(filename, message string) (string, error) {
+// LoadECDSAPrivateKey reads an ECDSA P-256 private key from a PEM file in SEC 1 or PKCS #8 form.
+func LoadECDSAPrivateKey(filename string) (*ecdsa.PrivateKey, error) {
+	pemData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PEM file: %v", err)
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block containing private key")
+	}
+
+	var privateKey *ecdsa.PrivateKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ECDSA private key: %v", err)
+		}
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS #8 private key: %v", err)
+		}
+		var ok bool
+		if privateKey, ok = key.(*ecdsa.PrivateKey); !ok {
+			return nil, errors.New("not ECDSA private key")
+		}
+	default:
+		return nil, fmt.Errorf("unexpected PEM block type %q", block.Type)
+	}
+
+	if privateKey.Curve != elliptic.P256() {
+		return nil, errors.New("ECDSA private key is not on the P-256 curve")
+	}
+
+	return privateKey, nil
+}
+
+// LoadECDSAPublicKey reads an ECDSA P-256 public key from a PEM file holding either the public key or its private key.
+func LoadECDSAPublicKey(filename string) (*ecdsa.PublicKey, error) {
 	pemData, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return "", fmt.Errorf("failed to read PEM file: %v", err)
+		return nil, fmt.Errorf("failed to read PEM file: %v", err)
 	}
 
 	block, _ := pem.Decode(pemData)
-	if block == nil || block.Type != "EC PRIVATE KEY" {
-		return "", fmt.Errorf("failed to decode PEM block containing private key")
+	if block != nil && block.Type == "PUBLIC KEY" {
+		return ParseECDSAPublicKeyFromPEM(string(pemData))
 	}
 
-	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	privateKey, err := LoadECDSAPrivateKey(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &privateKey.PublicKey, nil
+}
+
+// ReadPEMKeyAndSign reads the PEM key from the specified file, signs the message, and returns the signature in Base64URL format.
+func ReadPEMKeyAndSign(filename, message string) (string, error) {
+	signer, err := NewFileSigner(filename, "")
 	if err != nil {
-		return "", fmt.Errorf("failed to parse ECDSA private key: %v", err)
+		return "", err
 	}
 
 	hash := sha256.Sum256([]byte(message))
 
-	r, s, err := ecdsa.Sign(rand.Reader, privateKey, hash[:])
+	signature, err := signRaw(signer, hash[:])
 	if err != nil {
 		return "", fmt.Errorf("failed to sign message: %v", err)
 	}
 
-	signature := append(r.Bytes(), s.Bytes()...)
 	base64URLSignature := base64.URLEncoding.EncodeToString(signature)
 
 	return base64URLSignature, nil
@@ -76,19 +124,9 @@ func ReadPEMKeyAndSign(filename, message string) (string, error) {
 
 // ReadPEMKeyAndVerify reads the PEM key from the specified file and verifies the signature of the message.
 func ReadPEMKeyAndVerify(filename, message, base64URLSignature string) (bool, error) {
-	pemData, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return false, fmt.Errorf("failed to read PEM file: %v", err)
-	}
-
-	block, _ := pem.Decode(pemData)
-	if block == nil || block.Type != "EC PRIVATE KEY" {
-		return false, fmt.Errorf("failed to decode PEM block containing private key")
-	}
-
-	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	privateKey, err := LoadECDSAPrivateKey(filename)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse ECDSA private key: %v", err)
+		return false, err
 	}
 
 	publicKey := &privateKey.PublicKey
@@ -0,0 +1,278 @@
+package crypto
+
+import (
+	"context"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWKSContentType is the media type of a JWK Set.
+const JWKSContentType = "application/jwk-set+json"
+
+// JWK is the JSON Web Key form of a public key: an EC key on P-256 (Crv, X and Y), as this
+// server publishes, or an RSA key (N and E), as only read from an OAuth2 server's key set.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the JWK of a P-256 signing key published under kid.
+func NewJWK(kid string, publicKey *ecdsa.PublicKey) JWK {
+	x, y := encodeCoordinates(publicKey)
+	return JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   x,
+		Y:   y,
+		Kid: kid,
+		Use: "sig",
+		Alg: "ES256",
+	}
+}
+
+// PublicKey converts the JWK back into an ECDSA public key, checking that the point is on the P-256 curve.
+func (j JWK) PublicKey() (*ecdsa.PublicKey, error) {
+	if j.Kty != "EC" || j.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported key type %s %s", j.Kty, j.Crv)
+	}
+	if j.Alg != "" && j.Alg != "ES256" {
+		return nil, fmt.Errorf("unsupported algorithm %s", j.Alg)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil || len(x) != 32 {
+		return nil, errors.New("invalid x coordinate")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(j.Y)
+	if err != nil || len(y) != 32 {
+		return nil, errors.New("invalid y coordinate")
+	}
+
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("point is not on the P-256 curve")
+	}
+
+	return publicKey, nil
+}
+
+// VerificationKey converts the JWK into an ECDSA P-256 or RSA public key.
+func (j JWK) VerificationKey() (stdcrypto.PublicKey, error) {
+	if j.Kty != "RSA" {
+		return j.PublicKey()
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(j.N)
+	if err != nil || len(n) < 256 {
+		return nil, errors.New("invalid or shorter than 2048 bits RSA modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(j.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// Key returns the public key published under kid.
+func (s JWKS) Key(kid string) (*ecdsa.PublicKey, error) {
+	jwk, err := s.find(kid)
+	if err != nil {
+		return nil, err
+	}
+	return jwk.PublicKey()
+}
+
+// VerificationKey returns the ECDSA P-256 or RSA public key published under kid.
+func (s JWKS) VerificationKey(kid string) (stdcrypto.PublicKey, error) {
+	jwk, err := s.find(kid)
+	if err != nil {
+		return nil, err
+	}
+	return jwk.VerificationKey()
+}
+
+func (s JWKS) find(kid string) (JWK, error) {
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key, nil
+		}
+	}
+	return JWK{}, fmt.Errorf("no key with kid %q", kid)
+}
+
+// LoadJWKS reads a JWK Set from a JSON file.
+func LoadJWKS(filename string) (JWKS, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return JWKS{}, fmt.Errorf("failed to read JWKS: %v", err)
+	}
+
+	var keys JWKS
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return JWKS{}, fmt.Errorf("failed to decode JWKS %s: %v", filename, err)
+	}
+	return keys, nil
+}
+
+// JWKS returns the key set verifiers need: the active key and every retired key still in its overlap window.
+func (k *KeyRegistry) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.Keys() {
+		set.Keys = append(set.Keys, NewJWK(key.KeyID, key.PublicKey))
+	}
+	return set
+}
+
+// RemoteKeySet fetches and caches a JWK Set published at a URL, e.g. an issuer's /.well-known/jwks.json.
+type RemoteKeySet struct {
+	url        string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      JWKS
+	fetchedAt time.Time
+	expiresAt time.Time
+}
+
+const (
+	// defaultJWKSCacheTime is used when the key set response carries no max-age.
+	defaultJWKSCacheTime = 5 * time.Minute
+	// minJWKSRefreshInterval limits refetching on unknown key IDs.
+	minJWKSRefreshInterval = 10 * time.Second
+)
+
+// NewRemoteKeySet creates a key set fetched from url with httpClient.
+func NewRemoteKeySet(url string, httpClient *http.Client) *RemoteKeySet {
+	return &RemoteKeySet{url: url, httpClient: httpClient}
+}
+
+// Key returns the public key published under kid. The set is refetched when its cache
+// lifetime has passed or, at most every few seconds, when kid is unknown (e.g. after a rotation).
+func (s *RemoteKeySet) Key(ctx context.Context, kid string) (*ecdsa.PublicKey, error) {
+	jwk, err := s.find(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	return jwk.PublicKey()
+}
+
+// VerificationKey returns the ECDSA P-256 or RSA public key published under kid, refetching the set like Key.
+func (s *RemoteKeySet) VerificationKey(ctx context.Context, kid string) (stdcrypto.PublicKey, error) {
+	jwk, err := s.find(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	return jwk.VerificationKey()
+}
+
+func (s *RemoteKeySet) find(ctx context.Context, kid string) (JWK, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.expiresAt) {
+		if err := s.refresh(ctx, now); err != nil {
+			return JWK{}, err
+		}
+	}
+
+	jwk, err := s.keys.find(kid)
+	if err != nil && now.Sub(s.fetchedAt) >= minJWKSRefreshInterval {
+		if err := s.refresh(ctx, now); err != nil {
+			return JWK{}, err
+		}
+		jwk, err = s.keys.find(kid)
+	}
+
+	return jwk, err
+}
+
+// refresh fetches the key set. s.mu must be held.
+func (s *RemoteKeySet) refresh(ctx context.Context, now time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create JWKS request: %v", err)
+	}
+	req.Header.Set("Accept", JWKSContentType+", application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: received non-OK HTTP status: %s", resp.Status)
+	}
+
+	var keys JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&keys); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	s.keys = keys
+	s.fetchedAt = now
+	s.expiresAt = now.Add(cacheLifetime(resp.Header.Get("Cache-Control"), defaultJWKSCacheTime))
+
+	return nil
+}
+
+// cacheLifetime returns the max-age of a Cache-Control header, or fallback if it has none.
+func cacheLifetime(cacheControl string, fallback time.Duration) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+		if strings.HasPrefix(directive, "max-age=") {
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return fallback
+}
+
+// encodeCoordinates returns the base64url encoded, fixed length x and y coordinates of a P-256 key.
+func encodeCoordinates(publicKey *ecdsa.PublicKey) (string, string) {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	publicKey.X.FillBytes(x)
+	publicKey.Y.FillBytes(y)
+	return base64.RawURLEncoding.EncodeToString(x), base64.RawURLEncoding.EncodeToString(y)
+}
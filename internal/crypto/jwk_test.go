@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJWKS(t *testing.T) {
+	first, err := GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+	second, err := GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+
+	registry := NewKeyRegistry(first, time.Hour)
+	if err := registry.Rotate(second); err != nil {
+		t.Fatalf("Error rotating keys: %v", err)
+	}
+
+	data, err := json.Marshal(registry.JWKS())
+	if err != nil {
+		t.Fatalf("Error marshaling JWKS: %v", err)
+	}
+
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatalf("Error unmarshaling JWKS: %v", err)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(set.Keys))
+	}
+
+	for _, signer := range []Signer{first, second} {
+		publicKey, err := set.Key(signer.KeyID())
+		if err != nil {
+			t.Fatalf("Error looking up key %s: %v", signer.KeyID(), err)
+		}
+		if !publicKey.Equal(signer.Public()) {
+			t.Fatalf("Key %s does not round trip", signer.KeyID())
+		}
+		// The kid is the thumbprint, which only depends on the public key
+		if KeyID(publicKey) != signer.KeyID() {
+			t.Fatalf("Expected thumbprint %s, got %s", signer.KeyID(), KeyID(publicKey))
+		}
+	}
+
+	key := set.Keys[0]
+	if key.Kty != "EC" || key.Crv != "P-256" || key.Use != "sig" || key.Alg != "ES256" {
+		t.Fatalf("Unexpected JWK: %+v", key)
+	}
+
+	// Points off the curve are rejected
+	key.X, key.Y = key.Y, key.X
+	if _, err := key.PublicKey(); err == nil {
+		t.Fatalf("Expected point off the curve to be rejected")
+	}
+	if _, err := set.Key("unknown"); err == nil {
+		t.Fatalf("Expected unknown kid to be rejected")
+	}
+}
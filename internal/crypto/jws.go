@@ -2,22 +2,33 @@ package crypto
 
 import (
 	"crypto/ecdsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"strings"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	// StatusListJWTType is the JOSE "typ" header of a status list token.
+	StatusListJWTType = "statuslist+jwt"
+	// StatusListContentType is the media type of a status list token response.
+	StatusListContentType = "application/statuslist+jwt"
+	// HistoricalStatusListJWTType is the JOSE "typ" header of a token of a list as it was in
+	// the past, so verifiers of the current status never accept it.
+	HistoricalStatusListJWTType = "statuslist-historical+jwt"
+	// HistoricalStatusListContentType is the media type of a historical status list token response.
+	HistoricalStatusListContentType = "application/statuslist-historical+jwt"
+)
+
 // Status represents the status structure in the payload
 type Status struct {
+	Bits        int    `json:"bits"`
 	EncodedList string `json:"encodedList"`
 	Index       int    `json:"index"`
 }
@@ -30,6 +41,39 @@ type JWSResponse struct {
 	Status    Status `json:"status"`
 }
 
+// SignJWS signs the claims as a compact ES256 JWS with the signer's "kid" and the given "typ" header.
+func SignJWS(signer Signer, typ string, claims map[string]interface{}) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims(claims))
+	token.Header["kid"] = signer.KeyID()
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWS: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	signature, err := signRaw(signer, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWS: %v", err)
+	}
+
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// KeyID returns the RFC 7638 JWK thumbprint of a P-256 public key, used as its "kid".
+func KeyID(publicKey *ecdsa.PublicKey) string {
+	x, y := encodeCoordinates(publicKey)
+
+	// Members in lexicographic order, no whitespace
+	thumbprintInput := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, x, y)
+	sum := sha256.Sum256([]byte(thumbprintInput))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 // ParseJWSResponse parses the JWS response body and validates the signature
 func ParseJWSResponse(body []byte, publicKey *ecdsa.PublicKey) (Status, error) {
 	// Convert the body to a string
@@ -74,85 +118,81 @@ func ParseJWSResponse(body []byte, publicKey *ecdsa.PublicKey) (Status, error) {
 	return response.Status, nil
 }
 
-// Function to make an HTTP GET request to the specified URL and return the boolean status.
-func GetStatusFromJWS(url string, publicKey *ecdsa.PublicKey) (bool, error) {
-	// Make the HTTP GET request
-	resp, err := http.Get(url)
+// TokenStatusList is the "status_list" claim of an IETF Token Status List token
+type TokenStatusList struct {
+	Bits int    `json:"bits"`
+	Lst  string `json:"lst"`
+}
+
+// StatusListClaims represents the claims of an IETF Token Status List token
+type StatusListClaims struct {
+	Subject    string          `json:"sub"`
+	Issuer     string          `json:"iss,omitempty"`
+	IssuedAt   int64           `json:"iat"`
+	ExpiresAt  int64           `json:"exp,omitempty"`
+	TTL        int64           `json:"ttl,omitempty"`
+	StatusList TokenStatusList `json:"status_list"`
+	// Historical marks a token reconstructing the list as it was at Time, rather than
+	// its current state. Such tokens have their own type and subject and no "ttl".
+	Historical bool  `json:"historical,omitempty"`
+	Time       int64 `json:"time,omitempty"`
+}
+
+// ParseStatusListToken validates the signature, type and expiry of a status list token and returns its claims.
+// Historical tokens are rejected.
+func ParseStatusListToken(body []byte, publicKey *ecdsa.PublicKey) (*StatusListClaims, error) {
+	claims, err := parseStatusListToken(body, publicKey, StatusListJWTType)
 	if err != nil {
-		return false, fmt.Errorf("failed to make HTTP GET request: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("received non-OK HTTP status: %s", resp.Status)
+	if claims.Historical {
+		return nil, errors.New("status list token is historical")
 	}
+	return claims, nil
+}
 
-	// Read the response body
-	body, err := ioutil.ReadAll(resp.Body)
+// ParseHistoricalStatusListToken validates a token of a list as it was in the past like
+// ParseStatusListToken and returns its claims.
+func ParseHistoricalStatusListToken(body []byte, publicKey *ecdsa.PublicKey) (*StatusListClaims, error) {
+	claims, err := parseStatusListToken(body, publicKey, HistoricalStatusListJWTType)
 	if err != nil {
-		return false, fmt.Errorf("failed to read response body: %v", err)
+		return nil, err
+	}
+	if !claims.Historical {
+		return nil, errors.New("status list token is not historical")
 	}
+	return claims, nil
+}
 
-	// Parse and validate the JWS
-	token, err := jwt.Parse(string(body), func(token *jwt.Token) (interface{}, error) {
-		// Check the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+func parseStatusListToken(body []byte, publicKey *ecdsa.PublicKey, typ string) (*StatusListClaims, error) {
+	token, err := jwt.Parse(strings.TrimSpace(string(body)), func(token *jwt.Token) (interface{}, error) {
+		if header, _ := token.Header["typ"].(string); header != typ {
+			return nil, fmt.Errorf("unexpected token type: %v", token.Header["typ"])
 		}
 		return publicKey, nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}), jwt.WithIssuedAt())
 	if err != nil {
-		return false, fmt.Errorf("failed to parse JWS: %v", err)
+		return nil, fmt.Errorf("failed to parse status list token: %v", err)
 	}
 
-	// Check token validity
-	if !token.Valid {
-		return false, errors.New("invalid JWS token")
-	}
-
-	// Extract and validate the claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return false, errors.New("failed to extract claims")
-	}
-
-	iat := int64(claims["iat"].(float64))
-	exp := int64(claims["exp"].(float64))
-	iss := claims["iss"].(string)
-
-	if iat > time.Now().Unix() {
-		return false, errors.New("token used before issued")
-	}
-
-	if exp < time.Now().Unix() {
-		return false, errors.New("token expired")
+	claimsJSON, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, err
 	}
 
-	expectedIss := fmt.Sprintf("http://example.com/api/status/%s", strings.Split(url, "/")[4])
-	if iss != expectedIss {
-		return false, fmt.Errorf("unexpected issuer: %s", iss)
+	var claims StatusListClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("invalid status list claims: %v", err)
 	}
 
-	// Extract the status from the payload
-	statusPayload := claims["status"].(map[string]interface{})
-	encodedList := statusPayload["encodedList"].(string)
-	index := int(statusPayload["index"].(float64))
-
-	decodedList, err := base64.StdEncoding.DecodeString(encodedList)
-	if err != nil {
-		return false, fmt.Errorf("failed to decode encoded list: %v", err)
+	if claims.Subject == "" {
+		return nil, errors.New("status list token has no subject")
 	}
-
-	byteIndex := index / 8
-	bitIndex := index % 8
-
-	if byteIndex >= len(decodedList) {
-		return false, errors.New("index out of range")
+	if claims.StatusList.Lst == "" {
+		return nil, errors.New("status list token has no status_list claim")
 	}
 
-	status := (decodedList[byteIndex] & (1 << bitIndex)) != 0
-
-	return status, nil
+	return &claims, nil
 }
 
 // Utility function to parse the public key from a PEM-encoded string
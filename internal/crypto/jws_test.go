@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSignJWS(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ECDSA key: %v", err)
+	}
+	signer := NewMemorySigner(privateKey, "")
+	kid := KeyID(&privateKey.PublicKey)
+
+	claims := map[string]interface{}{
+		"iat": 1700000000,
+		"exp": 4100000000,
+		"iss": "http://localhost/api/status/1",
+		"status": map[string]interface{}{
+			"encodedList": "H4sIAAAAAAAA/2IEBAAA//+5xu/pAgAAAA==",
+			"index":       3,
+		},
+	}
+
+	// Sign the claims
+	signed, err := SignJWS(signer, StatusListJWTType, claims)
+	if err != nil {
+		t.Fatalf("Error signing JWS: %v", err)
+	}
+
+	// Check the protected header
+	token, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Error parsing JWS: %v", err)
+	}
+	if token.Header["alg"] != "ES256" || token.Header["kid"] != kid || token.Header["typ"] != StatusListJWTType {
+		t.Fatalf("Unexpected header: %v", token.Header)
+	}
+
+	// Verify and extract the status
+	status, err := ParseJWSResponse([]byte(signed), &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error parsing JWS response: %v", err)
+	}
+	if status.Index != 3 {
+		t.Fatalf("Expected index 3, got %d", status.Index)
+	}
+
+	// A different key must not verify the token
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ECDSA key: %v", err)
+	}
+	if _, err := ParseJWSResponse([]byte(signed), &otherKey.PublicKey); err == nil {
+		t.Fatalf("Expected verification with a different key to fail")
+	}
+}
+
+func TestParseStatusListToken(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ECDSA key: %v", err)
+	}
+	signer := NewMemorySigner(privateKey, "")
+
+	claims := map[string]interface{}{
+		"sub": "https://example.com/statuslists/1",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"ttl": 43200,
+		"status_list": map[string]interface{}{
+			"bits": 1,
+			"lst":  "eNrbuRgAAhcBXQ",
+		},
+	}
+
+	signed, err := SignJWS(signer, StatusListJWTType, claims)
+	if err != nil {
+		t.Fatalf("Error signing JWS: %v", err)
+	}
+
+	parsed, err := ParseStatusListToken([]byte(signed), &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error parsing status list token: %v", err)
+	}
+	if parsed.Subject != "https://example.com/statuslists/1" || parsed.TTL != 43200 || parsed.StatusList.Lst != "eNrbuRgAAhcBXQ" {
+		t.Fatalf("Unexpected claims: %+v", parsed)
+	}
+
+	// Tokens of another type are rejected
+	signed, err = SignJWS(signer, "JWT", claims)
+	if err != nil {
+		t.Fatalf("Error signing JWS: %v", err)
+	}
+	if _, err := ParseStatusListToken([]byte(signed), &privateKey.PublicKey); err == nil {
+		t.Fatalf("Expected token with typ JWT to be rejected")
+	}
+
+	// Expired tokens are rejected
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	signed, err = SignJWS(signer, StatusListJWTType, claims)
+	if err != nil {
+		t.Fatalf("Error signing JWS: %v", err)
+	}
+	if _, err := ParseStatusListToken([]byte(signed), &privateKey.PublicKey); err == nil {
+		t.Fatalf("Expected expired token to be rejected")
+	}
+
+	// Historical tokens only parse as such, whatever their type
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["historical"] = true
+	claims["sub"] = "https://example.com/statuslists/1?time=1700000000"
+	for _, typ := range []string{StatusListJWTType, HistoricalStatusListJWTType} {
+		signed, err = SignJWS(signer, typ, claims)
+		if err != nil {
+			t.Fatalf("Error signing JWS: %v", err)
+		}
+		if _, err := ParseStatusListToken([]byte(signed), &privateKey.PublicKey); err == nil {
+			t.Fatalf("Expected historical token with typ %s to be rejected", typ)
+		}
+	}
+	if parsed, err := ParseHistoricalStatusListToken([]byte(signed), &privateKey.PublicKey); err != nil || !parsed.Historical {
+		t.Fatalf("Expected a historical token, got %+v: %v", parsed, err)
+	}
+}
@@ -0,0 +1,159 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keyFileTimeFormat stamps key files with when they were generated, so their names sort by age.
+const keyFileTimeFormat = "20060102T150405.000000000Z"
+
+// KeyDir persists the signing keys generated by key rotation, so a restart neither loses
+// the active key nor the retired keys verifiers still need. Each key is a PEM file named
+// after when it was generated and its key ID; the newest is the active signing key.
+type KeyDir struct {
+	path string
+	now  func() time.Time
+}
+
+// OpenKeyDir opens the key directory at path, creating it readable by its owner only if missing.
+func OpenKeyDir(path string) (*KeyDir, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %v", err)
+	}
+	return &KeyDir{path: path, now: time.Now}, nil
+}
+
+// Generate creates a P-256 key, writes it to the directory readable by its owner only and
+// returns its signer. The key is on disk before it signs anything.
+func (d *KeyDir) Generate() (Signer, error) {
+	signer, err := GenerateMemorySigner()
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(signer.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ECDSA private key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	// TempFile creates the file with mode 0600; renaming it never exposes a partial key
+	tmp, err := ioutil.TempFile(d.path, ".key.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write key: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to write key: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write key: %v", err)
+	}
+	filename := filepath.Join(d.path, d.now().UTC().Format(keyFileTimeFormat)+"-"+signer.KeyID()+".pem")
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return nil, fmt.Errorf("failed to write key: %v", err)
+	}
+
+	return signer, nil
+}
+
+// Restore makes the newest key in the directory the active key of k, retiring the key k was
+// created with. Each older key stays published as long as a token it signed before its
+// successor was generated may be valid; the files of keys no token needs anymore are deleted.
+func (d *KeyDir) Restore(k *KeyRegistry) error {
+	keys, err := d.keys()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	retire := func(signer Signer, retiredAt time.Time) error {
+		if k.known(signer.KeyID()) {
+			return fmt.Errorf("key ID %s is already in use", signer.KeyID())
+		}
+		k.retired = append(k.retired, VerificationKey{
+			KeyID:       signer.KeyID(),
+			PublicKey:   signer.Public().(*ecdsa.PublicKey),
+			RetiredAt:   retiredAt,
+			VerifyUntil: retiredAt.Add(k.tokenLifetime),
+		})
+		return nil
+	}
+
+	newest := keys[len(keys)-1].signer
+	if k.known(newest.KeyID()) {
+		return fmt.Errorf("key ID %s is already in use", newest.KeyID())
+	}
+	previous := k.active
+	k.active = newest
+
+	// The key k was created with signed until the first key was generated
+	if now.Before(keys[0].generatedAt.Add(k.tokenLifetime)) {
+		if err := retire(previous, keys[0].generatedAt); err != nil {
+			return err
+		}
+	}
+
+	for i, key := range keys[:len(keys)-1] {
+		retiredAt := keys[i+1].generatedAt
+		if !now.Before(retiredAt.Add(k.tokenLifetime)) {
+			if err := os.Remove(key.filename); err != nil {
+				return fmt.Errorf("failed to delete expired key: %v", err)
+			}
+			continue
+		}
+		if err := retire(key.signer, retiredAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type storedKey struct {
+	filename    string
+	generatedAt time.Time
+	signer      *MemorySigner
+}
+
+// keys loads the keys in the directory, oldest first.
+func (d *KeyDir) keys() ([]storedKey, error) {
+	filenames, err := filepath.Glob(filepath.Join(d.path, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(filenames)
+
+	keys := make([]storedKey, 0, len(filenames))
+	for _, filename := range filenames {
+		name := filepath.Base(filename)
+		i := strings.Index(name, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("unexpected file %s in key directory", name)
+		}
+		generatedAt, err := time.Parse(keyFileTimeFormat, name[:i])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected file %s in key directory", name)
+		}
+
+		privateKey, err := LoadECDSAPrivateKey(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %v", name, err)
+		}
+		keys = append(keys, storedKey{filename: filename, generatedAt: generatedAt, signer: NewMemorySigner(privateKey, "")})
+	}
+	return keys, nil
+}
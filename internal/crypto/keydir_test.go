@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyDir(t *testing.T) {
+	dir, err := OpenKeyDir(filepath.Join(t.TempDir(), "keys"))
+	if err != nil {
+		t.Fatalf("Error opening key directory: %v", err)
+	}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var generated []Signer
+	for _, offset := range []time.Duration{0, time.Hour, 30 * time.Hour} {
+		dir.now = func() time.Time { return start.Add(offset) }
+		signer, err := dir.Generate()
+		if err != nil {
+			t.Fatalf("Error generating key: %v", err)
+		}
+		generated = append(generated, signer)
+	}
+
+	filenames, _ := filepath.Glob(filepath.Join(dir.path, "*.pem"))
+	if len(filenames) != 3 {
+		t.Fatalf("Expected 3 key files, got %v", filenames)
+	}
+	info, err := os.Stat(filenames[0])
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a key file readable by its owner only, got %v: %v", info.Mode(), err)
+	}
+
+	// After a restart, the newest key signs and its predecessor verifies for another day
+	initial, err := GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+	registry := NewKeyRegistry(initial, 24*time.Hour)
+	now := start.Add(31 * time.Hour)
+	registry.now = func() time.Time { return now }
+
+	if err := dir.Restore(registry); err != nil {
+		t.Fatalf("Error restoring keys: %v", err)
+	}
+	if registry.Active().KeyID() != generated[2].KeyID() {
+		t.Fatalf("Expected active key %s, got %s", generated[2].KeyID(), registry.Active().KeyID())
+	}
+	keys := registry.Keys()
+	if len(keys) != 2 || keys[1].KeyID != generated[1].KeyID() || !keys[1].VerifyUntil.Equal(start.Add(54*time.Hour)) {
+		t.Fatalf("Expected the second key to verify until %v, got %+v", start.Add(54*time.Hour), keys)
+	}
+
+	// Keys whose tokens have all expired are deleted
+	filenames, _ = filepath.Glob(filepath.Join(dir.path, "*.pem"))
+	if len(filenames) != 2 {
+		t.Fatalf("Expected the expired key file to be deleted, got %v", filenames)
+	}
+
+	// An empty directory leaves the registry alone
+	empty, err := OpenKeyDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error opening key directory: %v", err)
+	}
+	registry = NewKeyRegistry(initial, 24*time.Hour)
+	if err := empty.Restore(registry); err != nil || registry.Active() != Signer(initial) {
+		t.Fatalf("Expected the initial key to stay active, got %s: %v", registry.Active().KeyID(), err)
+	}
+
+	// The key the registry was created with verifies for a day after the first generated key replaced it
+	empty.now = func() time.Time { return start }
+	first, err := empty.Generate()
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	registry.now = func() time.Time { return start.Add(time.Hour) }
+	if err := empty.Restore(registry); err != nil {
+		t.Fatalf("Error restoring keys: %v", err)
+	}
+	keys = registry.Keys()
+	if len(keys) != 2 || keys[0].KeyID != first.KeyID() || keys[1].KeyID != initial.KeyID() || !keys[1].VerifyUntil.Equal(start.Add(24*time.Hour)) {
+		t.Fatalf("Expected the initial key to verify until %v, got %+v", start.Add(24*time.Hour), keys)
+	}
+}
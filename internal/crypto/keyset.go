@@ -0,0 +1,179 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// VerificationKey is a public key verifiers may need, published under its key ID.
+type VerificationKey struct {
+	KeyID     string
+	PublicKey *ecdsa.PublicKey
+	// Active is set for the key currently signing new tokens
+	Active bool
+	// RetiredAt is when the key stopped signing, zero for the active key
+	RetiredAt time.Time
+	// VerifyUntil is when the last token signed with the key expires, zero for the active key
+	VerifyUntil time.Time
+}
+
+// KeyRegistry holds the active signing key plus retired, verification-only keys. A retired
+// key stays published until every token it signed has expired, so verifiers can keep
+// validating tokens issued before a rotation.
+type KeyRegistry struct {
+	mu      sync.RWMutex
+	active  Signer
+	retired []VerificationKey
+
+	// tokenLifetime is the longest validity of a token signed with any key
+	tokenLifetime time.Duration
+	now           func() time.Time
+}
+
+// NewKeyRegistry creates a registry signing with active. tokenLifetime is the maximum
+// lifetime of issued tokens and therefore how long a retired key has to stay published.
+func NewKeyRegistry(active Signer, tokenLifetime time.Duration) *KeyRegistry {
+	return &KeyRegistry{
+		active:        active,
+		tokenLifetime: tokenLifetime,
+		now:           time.Now,
+	}
+}
+
+// Active returns the signer for new tokens.
+func (k *KeyRegistry) Active() Signer {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Rotate makes next the active signer and retires the previous one.
+func (k *KeyRegistry) Rotate(next Signer) error {
+	if _, ok := next.Public().(*ecdsa.PublicKey); !ok {
+		return fmt.Errorf("signer %s does not have an ECDSA public key", next.KeyID())
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.known(next.KeyID()) {
+		return fmt.Errorf("key ID %s is already in use", next.KeyID())
+	}
+
+	now := k.now()
+	k.retired = append(k.retired, VerificationKey{
+		KeyID:       k.active.KeyID(),
+		PublicKey:   k.active.Public().(*ecdsa.PublicKey),
+		RetiredAt:   now,
+		VerifyUntil: now.Add(k.tokenLifetime),
+	})
+	k.active = next
+	k.prune(now)
+
+	return nil
+}
+
+// AddRetired publishes a verification-only key until verifyUntil, e.g. the previous
+// signing key after a restart with a new one.
+func (k *KeyRegistry) AddRetired(kid string, publicKey *ecdsa.PublicKey, verifyUntil time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.known(kid) {
+		return fmt.Errorf("key ID %s is already in use", kid)
+	}
+
+	k.retired = append(k.retired, VerificationKey{
+		KeyID:       kid,
+		PublicKey:   publicKey,
+		RetiredAt:   k.now(),
+		VerifyUntil: verifyUntil,
+	})
+
+	return nil
+}
+
+// Keys returns the active key followed by every retired key that may still verify an unexpired token.
+func (k *KeyRegistry) Keys() []VerificationKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.prune(k.now())
+
+	keys := []VerificationKey{{
+		KeyID:     k.active.KeyID(),
+		PublicKey: k.active.Public().(*ecdsa.PublicKey),
+		Active:    true,
+	}}
+	return append(keys, k.retired...)
+}
+
+// Lookup returns the public key published under kid.
+func (k *KeyRegistry) Lookup(kid string) (*ecdsa.PublicKey, bool) {
+	for _, key := range k.Keys() {
+		if key.KeyID == kid {
+			return key.PublicKey, true
+		}
+	}
+	return nil, false
+}
+
+// StartRotation rotates to a key from generate every interval until the returned stop function is called.
+func (k *KeyRegistry) StartRotation(interval time.Duration, generate func() (Signer, error)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				next, err := generate()
+				if err == nil {
+					err = k.Rotate(next)
+				}
+				if err != nil {
+					log.Printf("Key rotation failed: %v", err)
+					continue
+				}
+				log.Printf("Rotated signing key to %s", next.KeyID())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// known reports whether kid is the active or a retired key. k.mu must be held.
+func (k *KeyRegistry) known(kid string) bool {
+	if k.active.KeyID() == kid {
+		return true
+	}
+	for _, key := range k.retired {
+		if key.KeyID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// prune drops retired keys whose tokens have all expired. k.mu must be held.
+func (k *KeyRegistry) prune(now time.Time) {
+	kept := k.retired[:0]
+	for _, key := range k.retired {
+		if now.Before(key.VerifyUntil) {
+			kept = append(kept, key)
+		}
+	}
+	k.retired = kept
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestKeyRegistry(t *testing.T) {
+	first, err := GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+	second, err := GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	registry := NewKeyRegistry(first, 24*time.Hour)
+	registry.now = func() time.Time { return now }
+
+	// Tokens are stamped with the kid of the active key
+	signed, err := SignJWS(registry.Active(), StatusListJWTType, map[string]interface{}{"sub": "https://example.com/statuslists/1"})
+	if err != nil {
+		t.Fatalf("Error signing JWS: %v", err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Error parsing JWS: %v", err)
+	}
+	if token.Header["kid"] != first.KeyID() {
+		t.Fatalf("Expected kid %s, got %v", first.KeyID(), token.Header["kid"])
+	}
+
+	if err := registry.Rotate(first); err == nil {
+		t.Fatalf("Expected rotating to the active key to be rejected")
+	}
+	if err := registry.Rotate(second); err != nil {
+		t.Fatalf("Error rotating keys: %v", err)
+	}
+	if registry.Active().KeyID() != second.KeyID() {
+		t.Fatalf("Expected active key %s, got %s", second.KeyID(), registry.Active().KeyID())
+	}
+
+	// The retired key is published until its last token expires
+	keys := registry.Keys()
+	if len(keys) != 2 || !keys[0].Active || keys[1].KeyID != first.KeyID() {
+		t.Fatalf("Expected active and retired key, got %+v", keys)
+	}
+	if !keys[1].VerifyUntil.Equal(now.Add(24 * time.Hour)) {
+		t.Fatalf("Expected retired key to verify until %v, got %v", now.Add(24*time.Hour), keys[1].VerifyUntil)
+	}
+	if _, ok := registry.Lookup(first.KeyID()); !ok {
+		t.Fatalf("Expected retired key to be found")
+	}
+
+	now = now.Add(24*time.Hour + time.Second)
+	if _, ok := registry.Lookup(first.KeyID()); ok {
+		t.Fatalf("Expected retired key to be dropped after its tokens expired")
+	}
+	if keys := registry.Keys(); len(keys) != 1 {
+		t.Fatalf("Expected only the active key, got %+v", keys)
+	}
+
+	// Scheduled rotation
+	registry.now = time.Now
+	stop := registry.StartRotation(10*time.Millisecond, func() (Signer, error) {
+		return GenerateMemorySigner()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for registry.Active().KeyID() == second.KeyID() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected scheduled rotation to replace the active key")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+	if _, ok := registry.Lookup(second.KeyID()); !ok {
+		t.Fatalf("Expected rotated key to stay published")
+	}
+}
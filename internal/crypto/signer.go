@@ -0,0 +1,307 @@
+package crypto
+
+import (
+	"bufio"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signer is an ECDSA P-256 crypto.Signer that knows the key ID stamped on the tokens it signs.
+// Implementations may keep the private key in memory, load it from a PEM file or delegate
+// signing to another process so the key never enters the API process.
+type Signer interface {
+	stdcrypto.Signer
+	KeyID() string
+}
+
+// MemorySigner signs with an ECDSA private key held in memory.
+type MemorySigner struct {
+	key *ecdsa.PrivateKey
+	kid string
+}
+
+// NewMemorySigner wraps privateKey in a Signer. An empty kid defaults to the JWK thumbprint.
+func NewMemorySigner(privateKey *ecdsa.PrivateKey, kid string) *MemorySigner {
+	if kid == "" {
+		kid = KeyID(&privateKey.PublicKey)
+	}
+	return &MemorySigner{key: privateKey, kid: kid}
+}
+
+// GenerateMemorySigner creates a Signer with a fresh P-256 key, e.g. for tests.
+func GenerateMemorySigner() (*MemorySigner, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ECDSA key: %v", err)
+	}
+	return NewMemorySigner(privateKey, ""), nil
+}
+
+// Public returns the public key of the signer.
+func (s *MemorySigner) Public() stdcrypto.PublicKey {
+	return &s.key.PublicKey
+}
+
+// Sign signs digest and returns an ASN.1 DER encoded signature.
+func (s *MemorySigner) Sign(rand io.Reader, digest []byte, opts stdcrypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+// KeyID returns the key ID of the signer.
+func (s *MemorySigner) KeyID() string {
+	return s.kid
+}
+
+// FileSigner signs with a private key loaded once from a PEM file.
+type FileSigner struct {
+	filename string
+	kid      string
+
+	mu     sync.RWMutex
+	signer *MemorySigner
+}
+
+// NewFileSigner loads the PEM key in filename. An empty kid defaults to the JWK thumbprint.
+func NewFileSigner(filename, kid string) (*FileSigner, error) {
+	s := &FileSigner{filename: filename, kid: kid}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the PEM file, e.g. after the key has been replaced on disk.
+func (s *FileSigner) Reload() error {
+	privateKey, err := LoadECDSAPrivateKey(s.filename)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.signer = NewMemorySigner(privateKey, s.kid)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *FileSigner) current() *MemorySigner {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.signer
+}
+
+// Public returns the public key of the signer.
+func (s *FileSigner) Public() stdcrypto.PublicKey {
+	return s.current().Public()
+}
+
+// Sign signs digest and returns an ASN.1 DER encoded signature.
+func (s *FileSigner) Sign(rand io.Reader, digest []byte, opts stdcrypto.SignerOpts) ([]byte, error) {
+	return s.current().Sign(rand, digest, opts)
+}
+
+// KeyID returns the key ID of the signer.
+func (s *FileSigner) KeyID() string {
+	return s.current().KeyID()
+}
+
+// signerRequest is a request of the socket signer protocol: one JSON object per line.
+type signerRequest struct {
+	Op     string `json:"op"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// signerResponse is a response of the socket signer protocol.
+type signerResponse struct {
+	KeyID     string `json:"kid,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RemoteSigner delegates signing to a process listening on a socket, like a PKCS #11 token
+// or HSM would, so the private key never enters the API process. See ServeSigner.
+type RemoteSigner struct {
+	network string
+	address string
+	timeout time.Duration
+
+	kid       string
+	publicKey *ecdsa.PublicKey
+}
+
+// DialSigner connects to a signer listening on address ("unix:///path/to/socket") and
+// fetches its public key and key ID. The signer authenticates nobody, so only Unix
+// sockets, guarded by their file permissions, are supported.
+func DialSigner(address string, timeout time.Duration) (*RemoteSigner, error) {
+	if !strings.HasPrefix(address, "unix://") {
+		return nil, fmt.Errorf("unsupported signer address %q, use unix:///path", address)
+	}
+	s := &RemoteSigner{network: "unix", address: strings.TrimPrefix(address, "unix://"), timeout: timeout}
+
+	response, err := s.call(signerRequest{Op: "public_key"})
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(response.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signer public key: %v", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signer public key: %v", err)
+	}
+	publicKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != elliptic.P256() {
+		return nil, errors.New("signer key is not an ECDSA P-256 key")
+	}
+
+	s.publicKey = publicKey
+	s.kid = response.KeyID
+	if s.kid == "" {
+		s.kid = KeyID(publicKey)
+	}
+
+	return s, nil
+}
+
+// Public returns the public key of the signer.
+func (s *RemoteSigner) Public() stdcrypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign sends digest to the signer and returns its ASN.1 DER encoded signature.
+func (s *RemoteSigner) Sign(_ io.Reader, digest []byte, _ stdcrypto.SignerOpts) ([]byte, error) {
+	response, err := s.call(signerRequest{Op: "sign", Digest: base64.StdEncoding.EncodeToString(digest)})
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %v", err)
+	}
+
+	// Never hand out a signature the key did not make
+	if !ecdsa.VerifyASN1(s.publicKey, digest, signature) {
+		return nil, errors.New("signer returned an invalid signature")
+	}
+
+	return signature, nil
+}
+
+// KeyID returns the key ID of the signer.
+func (s *RemoteSigner) KeyID() string {
+	return s.kid
+}
+
+func (s *RemoteSigner) call(request signerRequest) (*signerResponse, error) {
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signer: %v", err)
+	}
+	defer conn.Close()
+
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("failed to send signer request: %v", err)
+	}
+
+	var response signerResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read signer response: %v", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("signer error: %s", response.Error)
+	}
+
+	return &response, nil
+}
+
+// ServeSigner answers RemoteSigner requests on l with signer until l is closed. Anyone who
+// can connect to l can have digests signed, so l must only be reachable by the server.
+func ServeSigner(l net.Listener, signer Signer) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveSignerConn(conn, signer)
+	}
+}
+
+func serveSignerConn(conn net.Conn, signer Signer) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	var request signerRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&request); err != nil {
+		return
+	}
+
+	var response signerResponse
+	switch request.Op {
+	case "public_key":
+		der, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			response.Error = err.Error()
+			break
+		}
+		response.KeyID = signer.KeyID()
+		response.PublicKey = base64.StdEncoding.EncodeToString(der)
+	case "sign":
+		digest, err := base64.StdEncoding.DecodeString(request.Digest)
+		if err != nil || len(digest) != 32 {
+			response.Error = "digest must be a base64 encoded SHA-256 hash"
+			break
+		}
+		signature, err := signer.Sign(rand.Reader, digest, stdcrypto.SHA256)
+		if err != nil {
+			response.Error = err.Error()
+			break
+		}
+		response.Signature = base64.StdEncoding.EncodeToString(signature)
+	default:
+		response.Error = fmt.Sprintf("unknown op %q", request.Op)
+	}
+
+	json.NewEncoder(conn).Encode(response)
+}
+
+// signRaw signs digest with signer and returns the 64 byte r || s signature used by JWS.
+func signRaw(signer Signer, digest []byte) ([]byte, error) {
+	der, err := signer.Sign(rand.Reader, digest, stdcrypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %v", err)
+	}
+
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("failed to parse ASN.1 signature: %v", err)
+	}
+
+	raw := make([]byte, 64)
+	sig.R.FillBytes(raw[:32])
+	sig.S.FillBytes(raw[32:])
+	return raw, nil
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSigners(t *testing.T) {
+	memory, err := GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+
+	keyFilename := filepath.Join(t.TempDir(), "signer_test_key.pem")
+	if err := GenerateECDSAKey(keyFilename); err != nil {
+		t.Fatalf("Error generating ECDSA key: %v", err)
+	}
+	file, err := NewFileSigner(keyFilename, "file-key")
+	if err != nil {
+		t.Fatalf("Error loading file signer: %v", err)
+	}
+
+	// The remote signer talks to a signer served on a local socket
+	socket := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer listener.Close()
+	go ServeSigner(listener, memory)
+
+	remote, err := DialSigner("unix://"+socket, time.Second)
+	if err != nil {
+		t.Fatalf("Error dialing signer: %v", err)
+	}
+	if _, err := DialSigner("tcp://127.0.0.1:1", time.Second); err == nil {
+		t.Fatalf("Expected an error dialing a TCP signer")
+	}
+	if remote.KeyID() != memory.KeyID() {
+		t.Fatalf("Expected remote key ID %s, got %s", memory.KeyID(), remote.KeyID())
+	}
+
+	claims := map[string]interface{}{
+		"sub": "https://example.com/statuslists/1",
+		"iat": time.Now().Unix(),
+		"status_list": map[string]interface{}{
+			"bits": 1,
+			"lst":  "eNrbuRgAAhcBXQ",
+		},
+	}
+
+	for _, signer := range []Signer{memory, file, remote} {
+		signed, err := SignJWS(signer, StatusListJWTType, claims)
+		if err != nil {
+			t.Fatalf("Error signing with %T: %v", signer, err)
+		}
+
+		if _, err := ParseStatusListToken([]byte(signed), signer.Public().(*ecdsa.PublicKey)); err != nil {
+			t.Fatalf("Error verifying token signed with %T: %v", signer, err)
+		}
+	}
+
+	if file.KeyID() != "file-key" {
+		t.Fatalf("Expected key ID file-key, got %s", file.KeyID())
+	}
+
+	if _, err := DialSigner("ftp://example.com", time.Second); err == nil {
+		t.Fatalf("Expected unsupported signer address to be rejected")
+	}
+}
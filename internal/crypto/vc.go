@@ -0,0 +1,209 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+const (
+	// VCJWTType is the JOSE "typ" header of a credential secured as a VC-JWT.
+	VCJWTType = "vc+jwt"
+	// VCJWTContentType is the media type of a VC-JWT response.
+	VCJWTContentType = "application/vc+jwt"
+	// CredentialsContextV2 is the base JSON-LD context of the W3C VC Data Model 2.0.
+	CredentialsContextV2 = "https://www.w3.org/ns/credentials/v2"
+)
+
+// Status purposes of a W3C Bitstring Status List
+const (
+	PurposeRevocation = "revocation"
+	PurposeSuspension = "suspension"
+	PurposeMessage    = "message"
+)
+
+// ValidStatusPurpose reports whether purpose is a supported statusPurpose.
+func ValidStatusPurpose(purpose string) bool {
+	switch purpose {
+	case PurposeRevocation, PurposeSuspension, PurposeMessage:
+		return true
+	}
+	return false
+}
+
+// StatusMessage describes one value of a multi-bit status entry
+type StatusMessage struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// BitstringStatusList is the credentialSubject of a BitstringStatusListCredential
+type BitstringStatusList struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	StatusPurpose string          `json:"statusPurpose"`
+	EncodedList   string          `json:"encodedList"`
+	TTL           int64           `json:"ttl,omitempty"`
+	StatusSize    int             `json:"statusSize,omitempty"`
+	StatusMessage []StatusMessage `json:"statusMessage,omitempty"`
+}
+
+// BitstringStatusListCredential represents a W3C Bitstring Status List credential
+type BitstringStatusListCredential struct {
+	Context           []string            `json:"@context"`
+	ID                string              `json:"id"`
+	Type              []string            `json:"type"`
+	Issuer            string              `json:"issuer"`
+	ValidFrom         string              `json:"validFrom,omitempty"`
+	ValidUntil        string              `json:"validUntil,omitempty"`
+	CredentialSubject BitstringStatusList `json:"credentialSubject"`
+}
+
+// NewBitstringStatusListCredential builds a credential publishing sl under the given id for the given purpose.
+func NewBitstringStatusListCredential(id, issuer, purpose string, sl *status.StatusList, validFrom, validUntil time.Time, ttl time.Duration) (*BitstringStatusListCredential, error) {
+	if !ValidStatusPurpose(purpose) {
+		return nil, fmt.Errorf("invalid status purpose: %q", purpose)
+	}
+	if purpose != PurposeMessage && sl.Bits() != 1 {
+		return nil, fmt.Errorf("status purpose %q requires 1 bit per status", purpose)
+	}
+
+	encodedList, err := sl.EncodeBitstring()
+	if err != nil {
+		return nil, err
+	}
+
+	subject := BitstringStatusList{
+		ID:            id + "#list",
+		Type:          "BitstringStatusList",
+		StatusPurpose: purpose,
+		EncodedList:   encodedList,
+		TTL:           int64(ttl / time.Millisecond),
+	}
+	if purpose == PurposeMessage {
+		subject.StatusSize = sl.Bits()
+		subject.StatusMessage = defaultStatusMessages(sl.Bits())
+	}
+
+	return &BitstringStatusListCredential{
+		Context:           []string{CredentialsContextV2},
+		ID:                id,
+		Type:              []string{"VerifiableCredential", "BitstringStatusListCredential"},
+		Issuer:            issuer,
+		ValidFrom:         validFrom.UTC().Format(time.RFC3339),
+		ValidUntil:        validUntil.UTC().Format(time.RFC3339),
+		CredentialSubject: subject,
+	}, nil
+}
+
+// defaultStatusMessages describes every value of a statusSize bit entry.
+func defaultStatusMessages(statusSize int) []StatusMessage {
+	messages := make([]StatusMessage, 1<<uint(statusSize))
+	for value := range messages {
+		message := fmt.Sprintf("application specific 0x%x", value)
+		switch value {
+		case 0:
+			message = "valid"
+		case 1:
+			message = "invalid"
+		case 2:
+			message = "suspended"
+		}
+		messages[value] = StatusMessage{Status: fmt.Sprintf("0x%x", value), Message: message}
+	}
+	return messages
+}
+
+// SignCredential secures the credential as a VC-JWT signed with ES256.
+func SignCredential(signer Signer, credential *BitstringStatusListCredential) (string, error) {
+	credentialJSON, err := json.Marshal(credential)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal credential: %v", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(credentialJSON, &claims); err != nil {
+		return "", fmt.Errorf("failed to convert credential to claims: %v", err)
+	}
+
+	return SignJWS(signer, VCJWTType, claims)
+}
+
+// ParseBitstringStatusListCredential verifies a VC-JWT and returns the BitstringStatusListCredential it secures
+func ParseBitstringStatusListCredential(body []byte, publicKey *ecdsa.PublicKey) (*BitstringStatusListCredential, error) {
+	token, err := jwt.Parse(strings.TrimSpace(string(body)), func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != VCJWTType {
+			return nil, fmt.Errorf("unexpected token type: %v", token.Header["typ"])
+		}
+		return publicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credential: %v", err)
+	}
+
+	claimsJSON, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var credential BitstringStatusListCredential
+	if err := json.Unmarshal(claimsJSON, &credential); err != nil {
+		return nil, fmt.Errorf("invalid credential: %v", err)
+	}
+
+	if !containsString(credential.Type, "BitstringStatusListCredential") {
+		return nil, errors.New("not a BitstringStatusListCredential")
+	}
+	if credential.CredentialSubject.Type != "BitstringStatusList" {
+		return nil, errors.New("credentialSubject is not a BitstringStatusList")
+	}
+	if !ValidStatusPurpose(credential.CredentialSubject.StatusPurpose) {
+		return nil, fmt.Errorf("invalid status purpose: %q", credential.CredentialSubject.StatusPurpose)
+	}
+
+	now := time.Now()
+	if credential.ValidFrom != "" {
+		validFrom, err := time.Parse(time.RFC3339, credential.ValidFrom)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validFrom: %v", err)
+		}
+		if now.Before(validFrom) {
+			return nil, errors.New("credential is not yet valid")
+		}
+	}
+	if credential.ValidUntil != "" {
+		validUntil, err := time.Parse(time.RFC3339, credential.ValidUntil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validUntil: %v", err)
+		}
+		if now.After(validUntil) {
+			return nil, errors.New("credential expired")
+		}
+	}
+
+	return &credential, nil
+}
+
+// StatusList decodes the credential's encodedList.
+func (c *BitstringStatusListCredential) StatusList() (*status.StatusList, error) {
+	statusSize := c.CredentialSubject.StatusSize
+	if statusSize == 0 {
+		statusSize = 1
+	}
+	return status.DecodeBitstring(c.CredentialSubject.EncodedList, statusSize)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
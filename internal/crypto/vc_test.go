@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+func TestBitstringStatusListCredential(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ECDSA key: %v", err)
+	}
+	signer := NewMemorySigner(privateKey, "")
+
+	sl, _ := status.NewStatusListWithSize(1, 16)
+	sl.SetStatus(5, true)
+
+	now := time.Now()
+	id := "https://example.com/credentials/status/1"
+	credential, err := NewBitstringStatusListCredential(id, "https://example.com", PurposeRevocation, sl, now, now.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating credential: %v", err)
+	}
+
+	signed, err := SignCredential(signer, credential)
+	if err != nil {
+		t.Fatalf("Error signing credential: %v", err)
+	}
+
+	parsed, err := ParseBitstringStatusListCredential([]byte(signed), &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error parsing credential: %v", err)
+	}
+	if parsed.ID != id || parsed.CredentialSubject.ID != id+"#list" || parsed.CredentialSubject.StatusPurpose != PurposeRevocation {
+		t.Fatalf("Unexpected credential: %+v", parsed)
+	}
+	if parsed.CredentialSubject.TTL != 60000 {
+		t.Fatalf("Expected ttl 60000, got %d", parsed.CredentialSubject.TTL)
+	}
+
+	decoded, err := parsed.StatusList()
+	if err != nil {
+		t.Fatalf("Error decoding status list: %v", err)
+	}
+	for i := 0; i < sl.Len(); i++ {
+		value, _ := decoded.Get(i)
+		if (value == 1) != (i == 5) {
+			t.Fatalf("Unexpected status %d at index %d", value, i)
+		}
+	}
+
+	// Multi-bit lists are only valid for the message purpose
+	sl2, _ := status.NewStatusListWithSize(2, 16)
+	if _, err := NewBitstringStatusListCredential(id, "https://example.com", PurposeSuspension, sl2, now, now.Add(time.Hour), 0); err == nil {
+		t.Fatalf("Expected 2-bit suspension list to be rejected")
+	}
+	message, err := NewBitstringStatusListCredential(id, "https://example.com", PurposeMessage, sl2, now, now.Add(time.Hour), 0)
+	if err != nil {
+		t.Fatalf("Error creating message credential: %v", err)
+	}
+	if message.CredentialSubject.StatusSize != 2 || len(message.CredentialSubject.StatusMessage) != 4 {
+		t.Fatalf("Expected statusSize 2 with 4 messages, got %+v", message.CredentialSubject)
+	}
+
+	// Expired credentials are rejected
+	expired, _ := NewBitstringStatusListCredential(id, "https://example.com", PurposeRevocation, sl, now.Add(-2*time.Hour), now.Add(-time.Hour), 0)
+	signed, err = SignCredential(signer, expired)
+	if err != nil {
+		t.Fatalf("Error signing credential: %v", err)
+	}
+	if _, err := ParseBitstringStatusListCredential([]byte(signed), &privateKey.PublicKey); err == nil {
+		t.Fatalf("Expected expired credential to be rejected")
+	}
+}
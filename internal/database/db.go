@@ -6,25 +6,48 @@ import (
 	"log"
 
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 var DB *sql.DB
 
-func InitDB(dataSourceName string) error {
+// InitDB connects DB to a "postgres" or "sqlite" database.
+func InitDB(driver, dataSourceName string) error {
 	var err error
-	DB, err = sql.Open("postgres", dataSourceName)
+	DB, err = Open(driver, dataSourceName)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %v", err)
-	}
-
-	if err := DB.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+		return err
 	}
 
 	log.Println("Database connection established")
 	return nil
 }
 
+// Open connects to a "postgres" or "sqlite" database. Its schema is left to MigrateUp.
+func Open(driver, dataSourceName string) (*sql.DB, error) {
+	if driver != "postgres" && driver != "sqlite" {
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+
+	db, err := sql.Open(driver, dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	if driver == "sqlite" {
+		// SQLite allows a single writer, so serialize access rather than fail with SQLITE_BUSY.
+		// This also keeps a ":memory:" database from being private to one of several connections.
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	return db, nil
+}
+
 func CloseDB() {
 	if err := DB.Close(); err != nil {
 		log.Printf("Error closing database: %v", err)
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one schema change of a dialect, stored as NNN_name.up.sql and NNN_name.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration together with when it was applied, zero while pending.
+type MigrationState struct {
+	Migration
+	AppliedAt time.Time
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migrationLockID is the PostgreSQL advisory lock key serializing migration runs of
+// several instances started against the same database.
+const migrationLockID = 0x5354415455534d47
+
+// Migrations returns the migrations of a "postgres" or "sqlite" database ordered by version.
+func Migrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %s", driver)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		separator := strings.Index(base, "_")
+		if separator < 1 {
+			return nil, fmt.Errorf("migration file %s does not start with a version", name)
+		}
+		version, err := strconv.Atoi(base[:separator])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration file %s does not start with a version", name)
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: base[separator+1:]}
+			byVersion[version] = migration
+		} else if migration.Name != base[separator+1:] {
+			return nil, fmt.Errorf("migration %d has files named %s and %s", version, migration.Name, base[separator+1:])
+		}
+		if direction == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %03d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// MigrationStatus returns every known migration and when it was applied.
+func MigrationStatus(db *sql.DB, driver string) ([]MigrationState, error) {
+	migrations, err := Migrations(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnown(applied, migrations); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, len(migrations))
+	for i, migration := range migrations {
+		states[i] = MigrationState{Migration: migration, AppliedAt: applied[migration.Version]}
+	}
+	return states, nil
+}
+
+// MigrateUp applies every pending migration in order and returns how many it applied.
+// Instances migrating the same database at once take turns, and each applies only what
+// is still pending once it is its turn.
+func MigrateUp(db *sql.DB, driver string) (int, error) {
+	unlock, err := lockMigrations(db, driver)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	states, err := MigrationStatus(db, driver)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, state := range states {
+		if !state.AppliedAt.IsZero() {
+			continue
+		}
+		err := inTransaction(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(state.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", state.Version, state.Name)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("failed to apply migration %03d_%s: %v", state.Version, state.Name, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations and returns how many it reverted.
+func MigrateDown(db *sql.DB, driver string, steps int) (int, error) {
+	unlock, err := lockMigrations(db, driver)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	states, err := MigrationStatus(db, driver)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(states) - 1; i >= 0 && count < steps; i-- {
+		state := states[i]
+		if state.AppliedAt.IsZero() {
+			continue
+		}
+		err := inTransaction(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(state.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", state.Version)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("failed to revert migration %03d_%s: %v", state.Version, state.Name, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// appliedMigrations returns when each applied version was applied, creating the tracking table if needed.
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// checkKnown refuses to touch a database migrated by a newer build, whose schema this build does not know.
+func checkKnown(applied map[int]time.Time, migrations []Migration) error {
+	for version := range applied {
+		if version > len(migrations) {
+			return fmt.Errorf("database is at migration %d but this build only knows %d", version, len(migrations))
+		}
+	}
+	return nil
+}
+
+// lockMigrations takes the PostgreSQL advisory lock for migrations on a connection of its
+// own, waiting for another instance holding it, and returns the function releasing it.
+// SQLite needs no lock: each migration is a transaction, which SQLite runs one at a time.
+func lockMigrations(db *sql.DB, driver string) (func(), error) {
+	if driver != "postgres" {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to lock migrations: %v", err)
+	}
+
+	return func() {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+		conn.Close()
+	}, nil
+}
+
+func inTransaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
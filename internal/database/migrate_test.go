@@ -0,0 +1,120 @@
+package database
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	postgres, err := Migrations("postgres")
+	if err != nil {
+		t.Fatalf("Error loading postgres migrations: %v", err)
+	}
+	sqlite, err := Migrations("sqlite")
+	if err != nil {
+		t.Fatalf("Error loading sqlite migrations: %v", err)
+	}
+
+	// Both dialects share version numbers, so a version means the same schema everywhere
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("Expected as many sqlite as postgres migrations, got %d and %d", len(sqlite), len(postgres))
+	}
+	for i := range postgres {
+		if postgres[i].Name != sqlite[i].Name {
+			t.Fatalf("Expected migration %d to be %s in both dialects, got %s", postgres[i].Version, postgres[i].Name, sqlite[i].Name)
+		}
+	}
+
+	// PostgreSQL migrations create and add only what is missing, so they adopt databases set up by hand
+	creates := regexp.MustCompile(`(?i)(CREATE TABLE|CREATE INDEX|ADD COLUMN)(\s+IF NOT EXISTS)?`)
+	for _, migration := range postgres {
+		for _, match := range creates.FindAllStringSubmatch(migration.Up, -1) {
+			if match[2] == "" {
+				t.Fatalf("Expected %s in migration %03d_%s to use IF NOT EXISTS", match[1], migration.Version, migration.Name)
+			}
+		}
+	}
+
+	if _, err := Migrations("mysql"); err == nil {
+		t.Fatalf("Expected an error for an unknown driver")
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db, err := Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	migrations, err := Migrations("sqlite")
+	if err != nil {
+		t.Fatalf("Error loading migrations: %v", err)
+	}
+
+	applied, err := MigrateUp(db, "sqlite")
+	if err != nil {
+		t.Fatalf("Error migrating up: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Fatalf("Expected %d migrations applied, got %d", len(migrations), applied)
+	}
+	if _, err := db.Exec("INSERT INTO statuses (encoded_list, bits, allocated) VALUES ('', 1, '')"); err != nil {
+		t.Fatalf("Expected the migrated schema to match pkg/models: %v", err)
+	}
+
+	// Up again is a no-op
+	if applied, err := MigrateUp(db, "sqlite"); err != nil || applied != 0 {
+		t.Fatalf("Expected nothing to apply, got %d, %v", applied, err)
+	}
+
+	reverted, err := MigrateDown(db, "sqlite", 1)
+	if err != nil || reverted != 1 {
+		t.Fatalf("Expected 1 migration reverted, got %d, %v", reverted, err)
+	}
+	states, err := MigrationStatus(db, "sqlite")
+	if err != nil {
+		t.Fatalf("Error getting migration status: %v", err)
+	}
+	for i, state := range states {
+		pending := state.AppliedAt.IsZero()
+		if pending != (i == len(states)-1) {
+			t.Fatalf("Expected only the last migration pending, got %+v", states)
+		}
+	}
+
+	// Down past the first migration stops there
+	reverted, err = MigrateDown(db, "sqlite", len(migrations)+1)
+	if err != nil || reverted != len(migrations)-1 {
+		t.Fatalf("Expected %d migrations reverted, got %d, %v", len(migrations)-1, reverted, err)
+	}
+	if _, err := db.Exec("SELECT 1 FROM statuses"); err == nil {
+		t.Fatalf("Expected statuses to be dropped")
+	}
+
+	if applied, err := MigrateUp(db, "sqlite"); err != nil || applied != len(migrations) {
+		t.Fatalf("Expected %d migrations applied again, got %d, %v", len(migrations), applied, err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db, err := Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := MigrateUp(db, "sqlite"); err != nil {
+		t.Fatalf("Error migrating up: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (999, 'from_the_future')"); err != nil {
+		t.Fatalf("Error recording migration: %v", err)
+	}
+
+	if _, err := MigrateUp(db, "sqlite"); err == nil {
+		t.Fatalf("Expected an error migrating a database from a newer build")
+	}
+	if _, err := MigrateDown(db, "sqlite", 1); err == nil {
+		t.Fatalf("Expected an error reverting a database from a newer build")
+	}
+}
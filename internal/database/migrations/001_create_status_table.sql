@@ -1,4 +0,0 @@
-CREATE TABLE statuses (
-    id SERIAL PRIMARY KEY,
-    encoded_list BYTEA
-);
@@ -0,0 +1,5 @@
+-- IF NOT EXISTS adopts databases set up by running this file by hand
+CREATE TABLE IF NOT EXISTS statuses (
+    id SERIAL PRIMARY KEY,
+    encoded_list BYTEA
+);
@@ -0,0 +1 @@
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS bits SMALLINT NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+-- Allocation bitmap of each list; NULL for lists created before random allocation
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS allocated BYTEA;
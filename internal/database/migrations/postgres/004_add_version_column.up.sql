@@ -0,0 +1,2 @@
+-- Bumped by every write, so concurrent updates of a list can detect each other
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
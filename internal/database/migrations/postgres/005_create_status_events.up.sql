@@ -0,0 +1,14 @@
+-- Append-only log of status changes. It has no foreign key, so the history of a list
+-- outlives the list itself.
+CREATE TABLE IF NOT EXISTS status_events (
+    id BIGSERIAL PRIMARY KEY,
+    status_id INTEGER NOT NULL,
+    status_index INTEGER NOT NULL,
+    old_value SMALLINT NOT NULL,
+    new_value SMALLINT NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_events_entry ON status_events (status_id, status_index);
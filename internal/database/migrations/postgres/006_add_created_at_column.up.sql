@@ -0,0 +1,2 @@
+-- NULL for lists created before it was recorded
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,4 @@
+ALTER TABLE statuses DROP COLUMN sealed_at;
+ALTER TABLE statuses DROP COLUMN used;
+ALTER TABLE statuses DROP COLUMN capacity;
+ALTER TABLE statuses DROP COLUMN purpose;
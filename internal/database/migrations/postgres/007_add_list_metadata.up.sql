@@ -0,0 +1,6 @@
+-- capacity and used mirror the stored list so its metadata can be read without decoding it.
+-- They are NULL for lists last written before this migration.
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS purpose VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS capacity INTEGER;
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS used INTEGER;
+ALTER TABLE statuses ADD COLUMN IF NOT EXISTS sealed_at TIMESTAMP WITH TIME ZONE;
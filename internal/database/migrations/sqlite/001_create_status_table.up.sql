@@ -0,0 +1,5 @@
+-- AUTOINCREMENT keeps the IDs of deleted lists from being handed out again, as SERIAL does
+CREATE TABLE statuses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    encoded_list BLOB
+);
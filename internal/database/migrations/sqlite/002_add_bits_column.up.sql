@@ -0,0 +1 @@
+ALTER TABLE statuses ADD COLUMN bits INTEGER NOT NULL DEFAULT 1;
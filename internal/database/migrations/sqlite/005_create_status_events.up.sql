@@ -0,0 +1,14 @@
+-- Append-only log of status changes. It has no foreign key, so the history of a list
+-- outlives the list itself.
+CREATE TABLE status_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    status_id INTEGER NOT NULL,
+    status_index INTEGER NOT NULL,
+    old_value INTEGER NOT NULL,
+    new_value INTEGER NOT NULL,
+    actor TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_status_events_entry ON status_events (status_id, status_index);
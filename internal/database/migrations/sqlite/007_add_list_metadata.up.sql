@@ -0,0 +1,6 @@
+-- capacity and used mirror the stored list so its metadata can be read without decoding it.
+-- They are NULL for lists last written before this migration.
+ALTER TABLE statuses ADD COLUMN purpose TEXT NOT NULL DEFAULT '';
+ALTER TABLE statuses ADD COLUMN capacity INTEGER;
+ALTER TABLE statuses ADD COLUMN used INTEGER;
+ALTER TABLE statuses ADD COLUMN sealed_at TIMESTAMP;
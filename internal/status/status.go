@@ -3,57 +3,283 @@ package status
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"math/bits"
 )
 
-// StatusList represents a list of boolean statuses stored in a byte slice.
+// maxDecodedSize bounds the size of a decoded status list to guard against decompression bombs.
+const maxDecodedSize = 16 << 20
+
+// DefaultSize is the number of statuses in a new list. Large, pre-sized lists hide how many
+// credentials were issued and which were issued together (herd privacy).
+const DefaultSize = 131072
+
+// ErrListFull is returned when every index of a list has been allocated.
+var ErrListFull = errors.New("status list is full")
+
+// StatusList represents a list of statuses of a fixed bit width stored in a byte slice.
 type StatusList struct {
+	bits     int
 	statuses []byte
+	// allocated has one bit per status, set once its index has been handed out
+	allocated []byte
+	used      int
 }
 
-// NewStatusList creates a new StatusList.
+// NewStatusList creates a new StatusList of DefaultSize statuses with one bit per status.
 func NewStatusList() *StatusList {
+	sl, _ := NewStatusListWithSize(1, DefaultSize)
+	return sl
+}
+
+// NewStatusListWithBits creates a new StatusList of DefaultSize statuses with the given number of bits per status (1, 2, 4 or 8).
+func NewStatusListWithBits(bits int) (*StatusList, error) {
+	return NewStatusListWithSize(bits, DefaultSize)
+}
+
+// NewStatusListWithSize creates a new StatusList holding at least size statuses with the given number of bits per status.
+func NewStatusListWithSize(bits, size int) (*StatusList, error) {
+	if !ValidBits(bits) {
+		return nil, fmt.Errorf("invalid bits per status: %d", bits)
+	}
+	if size <= 0 || size > maxDecodedSize*8/bits {
+		return nil, fmt.Errorf("invalid status list size: %d", size)
+	}
+
+	perByte := 8 / bits
+	return newStatusList(bits, make([]byte, (size+perByte-1)/perByte)), nil
+}
+
+// newStatusList wraps statuses in a StatusList with no allocated indexes.
+func newStatusList(bits int, statuses []byte) *StatusList {
+	sl := &StatusList{
+		bits:     bits,
+		statuses: statuses,
+	}
+
+	sl.allocated = make([]byte, (sl.Len()+7)/8)
+	// Bits past the end of the list are never free
+	for index := sl.Len(); index < len(sl.allocated)*8; index++ {
+		sl.allocated[index/8] |= 1 << uint(index%8)
+	}
+
+	return sl
+}
+
+// Clone returns an independent copy of the list, including its allocations.
+func (sl *StatusList) Clone() *StatusList {
 	return &StatusList{
-		statuses: []byte{},
+		bits:      sl.bits,
+		statuses:  append([]byte(nil), sl.statuses...),
+		allocated: append([]byte(nil), sl.allocated...),
+		used:      sl.used,
+	}
+}
+
+// ValidBits reports whether bits is a supported number of bits per status.
+func ValidBits(bits int) bool {
+	switch bits {
+	case 1, 2, 4, 8:
+		return true
+	}
+	return false
+}
+
+// Bits returns the number of bits per status.
+func (sl *StatusList) Bits() int {
+	return sl.bits
+}
+
+// Len returns the number of statuses the list can hold.
+func (sl *StatusList) Len() int {
+	return len(sl.statuses) * 8 / sl.bits
+}
+
+// position returns the byte index and bit shift of the status at index.
+func (sl *StatusList) position(index int) (int, uint, error) {
+	if index < 0 || index >= sl.Len() {
+		return 0, 0, fmt.Errorf("index out of range")
+	}
+
+	perByte := 8 / sl.bits
+	return index / perByte, uint((index % perByte) * sl.bits), nil
+}
+
+// Set sets the status at the given index to value, which must fit in the list's bit width.
+func (sl *StatusList) Set(index int, value uint8) error {
+	if int(value) >= 1<<uint(sl.bits) {
+		return fmt.Errorf("value %d does not fit in %d bits", value, sl.bits)
+	}
+
+	byteIndex, shift, err := sl.position(index)
+	if err != nil {
+		return err
+	}
+
+	mask := byte(1<<uint(sl.bits)-1) << shift
+	sl.statuses[byteIndex] = sl.statuses[byteIndex]&^mask | value<<shift
+
+	return nil
+}
+
+// Get returns the status at the given index.
+func (sl *StatusList) Get(index int) (uint8, error) {
+	byteIndex, shift, err := sl.position(index)
+	if err != nil {
+		return 0, err
 	}
+
+	return sl.statuses[byteIndex] >> shift & byte(1<<uint(sl.bits)-1), nil
 }
 
 // SetStatus sets the status at the given index to the specified value (true or false).
 func (sl *StatusList) SetStatus(index int, value bool) error {
-	byteIndex := index / 8
-	bitIndex := index % 8
+	if value {
+		return sl.Set(index, 1)
+	}
+	return sl.Set(index, 0)
+}
+
+// AddStatus allocates an index with Allocate, sets its status to value and returns the index.
+func (sl *StatusList) AddStatus(value bool) (int, error) {
+	index, err := sl.Allocate()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := sl.SetStatus(index, value); err != nil {
+		return 0, err
+	}
+
+	return index, nil
+}
+
+// Allocate picks an unallocated index uniformly at random, marks it allocated and returns it.
+// Indexes are never handed out twice, so issuance order cannot be inferred from them.
+func (sl *StatusList) Allocate() (int, error) {
+	free := sl.Len() - sl.used
+	if free <= 0 {
+		return 0, ErrListFull
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(free)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to pick a random index: %v", err)
+	}
+
+	// Find the n-th free index, skipping whole bytes of the allocation bitmap
+	skip := int(n.Int64())
+	for byteIndex, b := range sl.allocated {
+		freeInByte := 8 - bits.OnesCount8(b)
+		if skip >= freeInByte {
+			skip -= freeInByte
+			continue
+		}
+		for bit := 0; bit < 8; bit++ {
+			if b&(1<<uint(bit)) != 0 {
+				continue
+			}
+			if skip == 0 {
+				index := byteIndex*8 + bit
+				sl.allocated[byteIndex] |= 1 << uint(bit)
+				sl.used++
+				return index, nil
+			}
+			skip--
+		}
+	}
+
+	return 0, ErrListFull
+}
 
-	if byteIndex >= len(sl.statuses) {
+// Reserve marks the index as allocated so Allocate never hands it out.
+func (sl *StatusList) Reserve(index int) error {
+	if index < 0 || index >= sl.Len() {
 		return fmt.Errorf("index out of range")
 	}
 
-	if value {
-		sl.statuses[byteIndex] |= (1 << bitIndex)
-	} else {
-		sl.statuses[byteIndex] &^= (1 << bitIndex)
+	if !sl.Allocated(index) {
+		sl.allocated[index/8] |= 1 << uint(index%8)
+		sl.used++
 	}
 
 	return nil
 }
 
-// AddStatus adds a new status to the list and returns its index.
-func (sl *StatusList) AddStatus(value bool) int {
-	index := len(sl.statuses) * 8
-	sl.statuses = append(sl.statuses, 0)
+// Allocated reports whether the index has been allocated.
+func (sl *StatusList) Allocated(index int) bool {
+	if index < 0 || index >= sl.Len() {
+		return false
+	}
+	return sl.allocated[index/8]&(1<<uint(index%8)) != 0
+}
 
-	if value {
-		sl.SetStatus(index, true)
+// Used returns the number of allocated indexes.
+func (sl *StatusList) Used() int {
+	return sl.used
+}
+
+// EncodeAllocations encodes the allocation bitmap into a gzipped base64 encoded string.
+func (sl *StatusList) EncodeAllocations() (string, error) {
+	return gzipBase64(sl.allocated)
+}
+
+// DecodeAllocations restores the allocation bitmap produced by EncodeAllocations.
+func (sl *StatusList) DecodeAllocations(encoded string) error {
+	allocated, err := gunzipBase64(encoded)
+	if err != nil {
+		return err
+	}
+	if len(allocated) != len(sl.allocated) {
+		return fmt.Errorf("allocation bitmap has %d bytes, expected %d", len(allocated), len(sl.allocated))
 	}
 
-	return index
+	// Start from a fresh bitmap so padding bits past the end of the list stay set
+	restored := newStatusList(sl.bits, sl.statuses).allocated
+	set := 0
+	for i, b := range allocated {
+		restored[i] |= b
+		set += bits.OnesCount8(restored[i])
+	}
+
+	sl.allocated = restored
+	sl.used = set - (len(restored)*8 - sl.Len())
+
+	return nil
 }
 
 // Encode encodes the status list into a gzipped base64 encoded string.
 func (sl *StatusList) Encode() (string, error) {
+	return gzipBase64(sl.statuses)
+}
+
+// Decode decodes a gzipped base64 encoded string produced by Encode into a StatusList with the given bits per status.
+// The decoded list has no allocated indexes; use DecodeAllocations to restore them.
+func Decode(encoded string, bits int) (*StatusList, error) {
+	if !ValidBits(bits) {
+		return nil, fmt.Errorf("invalid bits per status: %d", bits)
+	}
+
+	statuses, err := gunzipBase64(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStatusList(bits, statuses), nil
+}
+
+// gzipBase64 compresses data with gzip and encodes it with standard base64.
+func gzipBase64(data []byte) (string, error) {
 	var buffer bytes.Buffer
 	gzipWriter := gzip.NewWriter(&buffer)
-	_, err := gzipWriter.Write(sl.statuses)
+	_, err := gzipWriter.Write(data)
 	if err != nil {
 		return "", fmt.Errorf("failed to write to gzip writer: %v", err)
 	}
@@ -63,3 +289,138 @@ func (sl *StatusList) Encode() (string, error) {
 
 	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
 }
+
+// gunzipBase64 reverses gzipBase64.
+func gunzipBase64(encoded string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64: %v", err)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %v", err)
+	}
+	defer gzipReader.Close()
+
+	data, err := readStatuses(gzipReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from gzip reader: %v", err)
+	}
+
+	return data, nil
+}
+
+// EncodeLst encodes the status list as the "lst" member of an IETF Token Status List:
+// ZLIB compressed and base64url encoded without padding.
+func (sl *StatusList) EncodeLst() (string, error) {
+	var buffer bytes.Buffer
+	zlibWriter, err := zlib.NewWriterLevel(&buffer, zlib.BestCompression)
+	if err != nil {
+		return "", fmt.Errorf("failed to create zlib writer: %v", err)
+	}
+	if _, err := zlibWriter.Write(sl.statuses); err != nil {
+		return "", fmt.Errorf("failed to write to zlib writer: %v", err)
+	}
+	if err := zlibWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to close zlib writer: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+// DecodeLst decodes the "lst" member of an IETF Token Status List into a StatusList with the given bits per status.
+func DecodeLst(lst string, bits int) (*StatusList, error) {
+	if !ValidBits(bits) {
+		return nil, fmt.Errorf("invalid bits per status: %d", bits)
+	}
+
+	compressed, err := base64.RawURLEncoding.DecodeString(lst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64url: %v", err)
+	}
+
+	zlibReader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zlib reader: %v", err)
+	}
+	defer zlibReader.Close()
+
+	statuses, err := readStatuses(zlibReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from zlib reader: %v", err)
+	}
+
+	return newStatusList(bits, statuses), nil
+}
+
+// EncodeBitstring encodes the status list as the "encodedList" of a W3C Bitstring Status List:
+// statuses packed most significant bit first, GZIP compressed and multibase base64url encoded.
+func (sl *StatusList) EncodeBitstring() (string, error) {
+	bitstring := make([]byte, len(sl.statuses))
+	for index := 0; index < sl.Len(); index++ {
+		value, _ := sl.Get(index)
+		position := index * sl.bits
+		bitstring[position/8] |= value << uint(8-sl.bits-position%8)
+	}
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	if _, err := gzipWriter.Write(bitstring); err != nil {
+		return "", fmt.Errorf("failed to write to gzip writer: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to close gzip writer: %v", err)
+	}
+
+	// "u" is the multibase prefix for unpadded base64url
+	return "u" + base64.RawURLEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+// DecodeBitstring decodes the "encodedList" of a W3C Bitstring Status List into a StatusList with the given bits per status.
+func DecodeBitstring(encodedList string, bits int) (*StatusList, error) {
+	if !ValidBits(bits) {
+		return nil, fmt.Errorf("invalid bits per status: %d", bits)
+	}
+
+	if len(encodedList) == 0 || encodedList[0] != 'u' {
+		return nil, fmt.Errorf("encoded list is not multibase base64url")
+	}
+	compressed, err := base64.RawURLEncoding.DecodeString(encodedList[1:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64url: %v", err)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %v", err)
+	}
+	defer gzipReader.Close()
+
+	bitstring, err := readStatuses(gzipReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from gzip reader: %v", err)
+	}
+
+	sl := newStatusList(bits, make([]byte, len(bitstring)))
+	mask := byte(1<<uint(bits) - 1)
+	for index := 0; index < sl.Len(); index++ {
+		position := index * bits
+		sl.Set(index, bitstring[position/8]>>uint(8-bits-position%8)&mask)
+	}
+
+	return sl, nil
+}
+
+// readStatuses reads a decompressed status list of at most maxDecodedSize bytes.
+func readStatuses(r io.Reader) ([]byte, error) {
+	statuses, err := ioutil.ReadAll(io.LimitReader(r, maxDecodedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) > maxDecodedSize {
+		return nil, fmt.Errorf("decoded status list exceeds %d bytes", maxDecodedSize)
+	}
+
+	return statuses, nil
+}
@@ -1,22 +1,40 @@
 package status
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
 	"testing"
 )
 
 func TestStatusList(t *testing.T) {
 	sl := NewStatusList()
 
+	if sl.Len() != DefaultSize {
+		t.Fatalf("Expected %d statuses, got %d", DefaultSize, sl.Len())
+	}
+
 	// Add statuses
-	index1 := sl.AddStatus(true)
-	index2 := sl.AddStatus(false)
+	index1, err := sl.AddStatus(true)
+	if err != nil {
+		t.Fatalf("Error adding status: %v", err)
+	}
+	index2, err := sl.AddStatus(false)
+	if err != nil {
+		t.Fatalf("Error adding status: %v", err)
+	}
 
-	if index1 != 0 {
-		t.Fatalf("Expected index1 to be 0, got %d", index1)
+	if index1 == index2 {
+		t.Fatalf("Expected distinct indexes, got %d twice", index1)
 	}
 
-	if index2 != 8 {
-		t.Fatalf("Expected index2 to be 8, got %d", index2)
+	if value, _ := sl.Get(index1); value != 1 {
+		t.Fatalf("Expected status at index1 to be 1, got %d", value)
+	}
+
+	if sl.Used() != 2 || !sl.Allocated(index1) || !sl.Allocated(index2) {
+		t.Fatalf("Expected index1 and index2 to be allocated, used %d", sl.Used())
 	}
 
 	// Set statuses
@@ -34,3 +52,289 @@ func TestStatusList(t *testing.T) {
 		t.Fatalf("Expected encoded string to be non-empty")
 	}
 }
+
+func TestAllocate(t *testing.T) {
+	sl, err := NewStatusListWithSize(2, 10)
+	if err != nil {
+		t.Fatalf("Error creating status list: %v", err)
+	}
+	if sl.Len() != 12 {
+		t.Fatalf("Expected size to be rounded up to 12, got %d", sl.Len())
+	}
+
+	// Every index is handed out exactly once
+	seen := make(map[int]bool)
+	for i := 0; i < sl.Len(); i++ {
+		index, err := sl.Allocate()
+		if err != nil {
+			t.Fatalf("Error allocating index %d: %v", i, err)
+		}
+		if index < 0 || index >= sl.Len() || seen[index] {
+			t.Fatalf("Unexpected index %d", index)
+		}
+		seen[index] = true
+	}
+	if _, err := sl.Allocate(); err != ErrListFull {
+		t.Fatalf("Expected ErrListFull, got %v", err)
+	}
+
+	// Allocations survive an encode/decode round trip
+	sl, _ = NewStatusListWithSize(8, 5)
+	sl.Reserve(1)
+	sl.Reserve(3)
+	encoded, _ := sl.Encode()
+	allocations, err := sl.EncodeAllocations()
+	if err != nil {
+		t.Fatalf("Error encoding allocations: %v", err)
+	}
+	decoded, err := Decode(encoded, 8)
+	if err != nil {
+		t.Fatalf("Error decoding status list: %v", err)
+	}
+	if err := decoded.DecodeAllocations(allocations); err != nil {
+		t.Fatalf("Error decoding allocations: %v", err)
+	}
+	if decoded.Used() != 2 || !decoded.Allocated(1) || !decoded.Allocated(3) {
+		t.Fatalf("Expected indexes 1 and 3 to be allocated, used %d", decoded.Used())
+	}
+	for i := 0; i < 3; i++ {
+		index, err := decoded.Allocate()
+		if err != nil || index == 1 || index == 3 {
+			t.Fatalf("Unexpected allocation %d: %v", index, err)
+		}
+	}
+	if _, err := decoded.Allocate(); err != ErrListFull {
+		t.Fatalf("Expected ErrListFull, got %v", err)
+	}
+
+	// Allocation is spread over the whole list
+	sl = NewStatusList()
+	high := 0
+	for i := 0; i < 100; i++ {
+		index, _ := sl.Allocate()
+		if index >= DefaultSize/2 {
+			high++
+		}
+	}
+	if high < 20 || high > 80 {
+		t.Fatalf("Expected about half of the indexes in the upper half, got %d of 100", high)
+	}
+}
+
+func TestMultiBitStatusList(t *testing.T) {
+	if _, err := NewStatusListWithBits(3); err == nil {
+		t.Fatalf("Expected 3 bits per status to be rejected")
+	}
+
+	for _, bits := range []int{1, 2, 4, 8} {
+		sl, err := NewStatusListWithSize(bits, 16/bits)
+		if err != nil {
+			t.Fatalf("Error creating %d-bit status list: %v", bits, err)
+		}
+
+		if sl.Len() != 16/bits {
+			t.Fatalf("Expected %d statuses with %d bits, got %d", 16/bits, bits, sl.Len())
+		}
+
+		// Set every status to a distinct value and read them back
+		max := 1<<uint(bits) - 1
+		for i := 0; i < sl.Len(); i++ {
+			if err := sl.Set(i, uint8(i%(max+1))); err != nil {
+				t.Fatalf("Error setting status %d with %d bits: %v", i, bits, err)
+			}
+		}
+		for i := 0; i < sl.Len(); i++ {
+			value, err := sl.Get(i)
+			if err != nil {
+				t.Fatalf("Error getting status %d with %d bits: %v", i, bits, err)
+			}
+			if int(value) != i%(max+1) {
+				t.Fatalf("Expected status %d with %d bits to be %d, got %d", i, bits, i%(max+1), value)
+			}
+		}
+
+		if err := sl.Set(0, uint8(max+1)); bits < 8 && err == nil {
+			t.Fatalf("Expected value %d to be rejected with %d bits", max+1, bits)
+		}
+		if _, err := sl.Get(sl.Len()); err == nil {
+			t.Fatalf("Expected index %d to be out of range", sl.Len())
+		}
+	}
+
+	// Two bit statuses are packed least significant bits first
+	sl, _ := NewStatusListWithSize(2, 4)
+	sl.Set(0, 1)
+	sl.Set(1, 2)
+	sl.Set(3, 3)
+	if sl.statuses[0] != 0xC9 {
+		t.Fatalf("Expected packed byte 0xC9, got %#x", sl.statuses[0])
+	}
+}
+
+func TestDecode(t *testing.T) {
+	sl, err := NewStatusListWithSize(2, 8)
+	if err != nil {
+		t.Fatalf("Error creating status list: %v", err)
+	}
+	sl.Set(1, 3)
+	sl.Set(6, 2)
+
+	encoded, err := sl.Encode()
+	if err != nil {
+		t.Fatalf("Error encoding status list: %v", err)
+	}
+
+	// Round trip
+	decoded, err := Decode(encoded, 2)
+	if err != nil {
+		t.Fatalf("Error decoding status list: %v", err)
+	}
+	if decoded.Bits() != 2 || decoded.Len() != sl.Len() {
+		t.Fatalf("Expected %d 2-bit statuses, got %d %d-bit statuses", sl.Len(), decoded.Len(), decoded.Bits())
+	}
+	for i := 0; i < sl.Len(); i++ {
+		want, _ := sl.Get(i)
+		got, _ := decoded.Get(i)
+		if got != want {
+			t.Fatalf("Expected status %d to be %d, got %d", i, want, got)
+		}
+	}
+
+	reencoded, err := decoded.Encode()
+	if err != nil {
+		t.Fatalf("Error re-encoding status list: %v", err)
+	}
+	if reencoded != encoded {
+		t.Fatalf("Expected re-encoded list %q, got %q", encoded, reencoded)
+	}
+
+	// A new list round trips as well
+	empty, err := NewStatusList().Encode()
+	if err != nil {
+		t.Fatalf("Error encoding empty status list: %v", err)
+	}
+	if decoded, err := Decode(empty, 1); err != nil || decoded.Len() != DefaultSize {
+		t.Fatalf("Expected empty status list of %d statuses, got %v", DefaultSize, err)
+	}
+
+	// Invalid input
+	if _, err := Decode(encoded, 3); err == nil {
+		t.Fatalf("Expected invalid bits to be rejected")
+	}
+	if _, err := Decode("not base64!", 1); err == nil {
+		t.Fatalf("Expected invalid base64 to be rejected")
+	}
+	if _, err := Decode("aGVsbG8=", 1); err == nil {
+		t.Fatalf("Expected non-gzip data to be rejected")
+	}
+	if _, err := Decode(encoded[:len(encoded)-8], 2); err == nil {
+		t.Fatalf("Expected truncated data to be rejected")
+	}
+}
+
+func TestEncodeLst(t *testing.T) {
+	// Example from the IETF Token Status List draft: statuses 0, 3, 4, 5, 7, 8, 9, 13 and 15 are set
+	sl, _ := NewStatusListWithSize(1, 16)
+	for _, index := range []int{0, 3, 4, 5, 7, 8, 9, 13, 15} {
+		if err := sl.SetStatus(index, true); err != nil {
+			t.Fatalf("Error setting status %d: %v", index, err)
+		}
+	}
+	if sl.statuses[0] != 0xB9 || sl.statuses[1] != 0xA3 {
+		t.Fatalf("Expected bytes 0xB9 0xA3, got %#x %#x", sl.statuses[0], sl.statuses[1])
+	}
+
+	// The draft's lst decodes to the same list
+	example, err := DecodeLst("eNrbuRgAAhcBXQ", 1)
+	if err != nil {
+		t.Fatalf("Error decoding example lst: %v", err)
+	}
+
+	lst, err := sl.EncodeLst()
+	if err != nil {
+		t.Fatalf("Error encoding lst: %v", err)
+	}
+	decoded, err := DecodeLst(lst, 1)
+	if err != nil {
+		t.Fatalf("Error decoding lst: %v", err)
+	}
+
+	for _, other := range []*StatusList{example, decoded} {
+		if other.Len() != sl.Len() {
+			t.Fatalf("Expected %d statuses, got %d", sl.Len(), other.Len())
+		}
+		for i := 0; i < sl.Len(); i++ {
+			want, _ := sl.Get(i)
+			got, _ := other.Get(i)
+			if got != want {
+				t.Fatalf("Expected status %d to be %d, got %d", i, want, got)
+			}
+		}
+	}
+
+	if _, err := DecodeLst(lst+"=", 1); err == nil {
+		t.Fatalf("Expected padded base64url to be rejected")
+	}
+}
+
+func TestEncodeBitstring(t *testing.T) {
+	sl, _ := NewStatusListWithSize(1, 8)
+	sl.SetStatus(0, true)
+	sl.SetStatus(6, true)
+
+	encoded, err := sl.EncodeBitstring()
+	if err != nil {
+		t.Fatalf("Error encoding bitstring: %v", err)
+	}
+	if encoded[0] != 'u' {
+		t.Fatalf("Expected multibase prefix u, got %q", encoded[0])
+	}
+
+	// Status 0 is the most significant bit of the first byte
+	if bitstring := mustGunzipBase64URL(t, encoded[1:]); len(bitstring) != 1 || bitstring[0] != 0x82 {
+		t.Fatalf("Expected bitstring 0x82, got %#x", bitstring)
+	}
+
+	for _, bits := range []int{1, 2, 4, 8} {
+		sl, _ := NewStatusListWithSize(bits, 16/bits)
+		for i := 0; i < sl.Len(); i++ {
+			sl.Set(i, uint8((i*7+1)%(1<<uint(bits))))
+		}
+
+		encoded, err := sl.EncodeBitstring()
+		if err != nil {
+			t.Fatalf("Error encoding %d-bit bitstring: %v", bits, err)
+		}
+		decoded, err := DecodeBitstring(encoded, bits)
+		if err != nil {
+			t.Fatalf("Error decoding %d-bit bitstring: %v", bits, err)
+		}
+		for i := 0; i < sl.Len(); i++ {
+			want, _ := sl.Get(i)
+			got, _ := decoded.Get(i)
+			if got != want {
+				t.Fatalf("Expected %d-bit status %d to be %d, got %d", bits, i, want, got)
+			}
+		}
+	}
+
+	if _, err := DecodeBitstring(encoded[1:], 1); err == nil {
+		t.Fatalf("Expected encoded list without multibase prefix to be rejected")
+	}
+}
+
+func mustGunzipBase64URL(t *testing.T, encoded string) []byte {
+	compressed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("Error decoding base64url: %v", err)
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Error creating gzip reader: %v", err)
+	}
+	data, err := ioutil.ReadAll(gzipReader)
+	if err != nil {
+		t.Fatalf("Error reading gzip data: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+// Audit says who changes a status list and why.
+type Audit struct {
+	// Actor is the authenticated principal making the change
+	Actor string
+	// Reason is an optional reason code given by the actor
+	Reason string
+}
+
+// StatusEvent records one change of a status entry.
+type StatusEvent struct {
+	StatusId string    `json:"statusId"`
+	Index    int       `json:"index"`
+	OldValue uint8     `json:"oldValue"`
+	NewValue uint8     `json:"newValue"`
+	Actor    string    `json:"actor"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// statusEvents returns an event for every entry whose value differs between before and after.
+func statusEvents(statusId string, before, after *status.StatusList, audit Audit, at time.Time) []StatusEvent {
+	var events []StatusEvent
+	for index := 0; index < after.Len(); index++ {
+		oldValue, _ := before.Get(index)
+		newValue, _ := after.Get(index)
+		if oldValue != newValue {
+			events = append(events, StatusEvent{
+				StatusId: statusId,
+				Index:    index,
+				OldValue: oldValue,
+				NewValue: newValue,
+				Actor:    audit.Actor,
+				Reason:   audit.Reason,
+				Time:     at,
+			})
+		}
+	}
+	return events
+}
+
+// revertEvents undoes events, given newest first, on list.
+func revertEvents(list *status.StatusList, events []StatusEvent) error {
+	for _, event := range events {
+		if err := list.Set(event.Index, event.OldValue); err != nil {
+			return fmt.Errorf("failed to revert change of index %d: %v", event.Index, err)
+		}
+	}
+	return nil
+}
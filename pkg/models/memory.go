@@ -0,0 +1,194 @@
+package models
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+// MemoryRepository keeps status lists in memory. It is safe for concurrent use and hands
+// out copies, so callers see the same isolation as with a database.
+type MemoryRepository struct {
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+	events  []StatusEvent
+	nextId  int
+}
+
+type memoryEntry struct {
+	list      *status.StatusList
+	purpose   string
+	createdAt time.Time
+	sealedAt  *time.Time
+}
+
+// NewMemoryRepository creates an empty MemoryRepository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		entries: make(map[string]*memoryEntry),
+		nextId:  1,
+	}
+}
+
+func (m *MemoryRepository) GetStatus(statusId string) (*status.StatusList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[statusId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return entry.list.Clone(), nil
+}
+
+func (m *MemoryRepository) SaveStatus(statusId string, status *status.StatusList) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[statusId]
+	if !ok {
+		return ErrNotFound
+	}
+	entry.list = status.Clone()
+	entry.sealedAt = sealedAt(entry.list, entry.sealedAt, time.Now().UTC())
+	return nil
+}
+
+func (m *MemoryRepository) UpdateStatus(statusId string, audit Audit, fn func(*status.StatusList) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[statusId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	updated := entry.list.Clone()
+	if err := fn(updated); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	m.events = append(m.events, statusEvents(statusId, entry.list, updated, audit, now)...)
+	entry.list = updated
+	entry.sealedAt = sealedAt(updated, entry.sealedAt, now)
+	return nil
+}
+
+func (m *MemoryRepository) StatusHistory(statusId string, index int) ([]StatusEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history := []StatusEvent{}
+	for _, event := range m.events {
+		if event.StatusId == statusId && event.Index == index {
+			history = append(history, event)
+		}
+	}
+	return history, nil
+}
+
+func (m *MemoryRepository) CreateNewStatus(status *status.StatusList, purpose string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	statusId := strconv.Itoa(m.nextId)
+	m.nextId++
+	m.entries[statusId] = &memoryEntry{
+		list:      status.Clone(),
+		purpose:   purpose,
+		createdAt: now,
+		sealedAt:  sealedAt(status, nil, now),
+	}
+	return statusId, nil
+}
+
+func (m *MemoryRepository) GetStatusMeta(statusId string) (*StatusMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[statusId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	meta := entry.meta(statusId)
+	return &meta, nil
+}
+
+func (m *MemoryRepository) ListStatusMeta() ([]StatusMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	metas := make([]StatusMeta, 0, len(m.entries))
+	for _, statusId := range m.sortedIds() {
+		metas = append(metas, m.entries[statusId].meta(statusId))
+	}
+	return metas, nil
+}
+
+func (m *MemoryRepository) GetAllStatusIds() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedIds(), nil
+}
+
+func (m *MemoryRepository) DeleteStatus(statusId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[statusId]; !ok {
+		return ErrNotFound
+	}
+	delete(m.entries, statusId)
+	return nil
+}
+
+func (m *MemoryRepository) StatusListAt(statusId string, at time.Time) (*status.StatusList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[statusId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if at.Before(entry.createdAt) {
+		return nil, ErrNotYetCreated
+	}
+
+	var later []StatusEvent
+	for i := len(m.events) - 1; i >= 0; i-- {
+		if event := m.events[i]; event.StatusId == statusId && event.Time.After(at) {
+			later = append(later, event)
+		}
+	}
+
+	past := entry.list.Clone()
+	if err := revertEvents(past, later); err != nil {
+		return nil, err
+	}
+	return past, nil
+}
+
+// sortedIds returns the IDs of all lists in numeric order, like the SERIAL ids of the database. m.mu must be held.
+func (m *MemoryRepository) sortedIds() []string {
+	statusIds := make([]string, 0, len(m.entries))
+	for id := range m.entries {
+		statusIds = append(statusIds, id)
+	}
+	sort.Slice(statusIds, func(i, j int) bool {
+		a, _ := strconv.Atoi(statusIds[i])
+		b, _ := strconv.Atoi(statusIds[j])
+		return a < b
+	})
+	return statusIds
+}
+
+func (e *memoryEntry) meta(statusId string) StatusMeta {
+	createdAt := e.createdAt
+	return newStatusMeta(statusId, e.purpose, e.list, &createdAt, e.sealedAt)
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+// StatusMeta describes a status list without its statuses.
+type StatusMeta struct {
+	StatusId string `json:"statusId"`
+	// Purpose is the W3C statusPurpose of the list, empty for lists created without one
+	Purpose  string `json:"purpose,omitempty"`
+	Bits     int    `json:"bits"`
+	Capacity int    `json:"capacity"`
+	Used     int    `json:"used"`
+	// Sealed is set once every index is allocated; new entries then go to another list
+	Sealed    bool       `json:"sealed"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	SealedAt  *time.Time `json:"sealedAt,omitempty"`
+}
+
+// newStatusMeta describes list. createdAt and sealedAt are nil when not recorded.
+func newStatusMeta(statusId, purpose string, list *status.StatusList, createdAt, sealedAt *time.Time) StatusMeta {
+	return StatusMeta{
+		StatusId:  statusId,
+		Purpose:   purpose,
+		Bits:      list.Bits(),
+		Capacity:  list.Len(),
+		Used:      list.Used(),
+		Sealed:    list.Used() >= list.Len(),
+		CreatedAt: createdAt,
+		SealedAt:  sealedAt,
+	}
+}
+
+// sealedAt returns when list was sealed: previous if it already was, now if it just filled up, else nil.
+func sealedAt(list *status.StatusList, previous *time.Time, now time.Time) *time.Time {
+	if previous != nil {
+		return previous
+	}
+	if list.Used() >= list.Len() {
+		return &now
+	}
+	return nil
+}
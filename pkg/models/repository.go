@@ -0,0 +1,95 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+// ErrNotFound is returned when a status list does not exist.
+var ErrNotFound = errors.New("status not found")
+
+// ErrNotYetCreated is returned when a list is asked for as of a time before it was created.
+var ErrNotYetCreated = errors.New("status list did not exist yet")
+
+// ErrConflict is returned when an update kept losing against concurrent updates of the same list.
+var ErrConflict = errors.New("status list is being updated concurrently")
+
+// StatusRepository stores status lists under string IDs.
+type StatusRepository interface {
+	// GetStatus returns the list stored under statusId, or ErrNotFound.
+	GetStatus(statusId string) (*status.StatusList, error)
+	// SaveStatus overwrites the list stored under statusId, or returns ErrNotFound. Changes
+	// made concurrently since the list was read are lost; use UpdateStatus to modify a list.
+	SaveStatus(statusId string, status *status.StatusList) error
+	// UpdateStatus applies fn to the current list stored under statusId and saves the result,
+	// without losing concurrent updates. Every changed entry is recorded as a StatusEvent
+	// attributed to audit. fn may be called again with a fresh copy if another update got in
+	// first, so it must not have side effects beyond the list. An error from fn aborts the
+	// update and is returned as is.
+	UpdateStatus(statusId string, audit Audit, fn func(*status.StatusList) error) error
+	// StatusHistory returns the recorded changes of one entry, oldest first. The history
+	// of a deleted list is kept.
+	StatusHistory(statusId string, index int) ([]StatusEvent, error)
+	// StatusListAt reconstructs the statuses of a list as they were at the given time by
+	// undoing the recorded changes made since. It returns ErrNotFound for a deleted list and
+	// ErrNotYetCreated for a time before the list was created. Changes stored with SaveStatus
+	// are not recorded and cannot be undone.
+	StatusListAt(statusId string, at time.Time) (*status.StatusList, error)
+	// CreateNewStatus stores a new list for the given W3C statusPurpose and returns its ID.
+	CreateNewStatus(status *status.StatusList, purpose string) (string, error)
+	// GetStatusMeta describes the list stored under statusId, or returns ErrNotFound.
+	GetStatusMeta(statusId string) (*StatusMeta, error)
+	// ListStatusMeta describes all lists, ordered by ID.
+	ListStatusMeta() ([]StatusMeta, error)
+	// GetAllStatusIds returns the IDs of all lists.
+	GetAllStatusIds() ([]string, error)
+	// DeleteStatus removes the list stored under statusId, or returns ErrNotFound.
+	DeleteStatus(statusId string) error
+}
+
+// encodeStatusList returns the stored form of a list: its encoded statuses and allocations.
+func encodeStatusList(status *status.StatusList) (string, string, error) {
+	encodedList, err := status.Encode()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode status list: %v", err)
+	}
+
+	allocated, err := status.EncodeAllocations()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode allocations: %v", err)
+	}
+
+	return encodedList, allocated, nil
+}
+
+// decodeStatusList restores a list from its stored form.
+func decodeStatusList(encodedList, allocated []byte, bits int) (*status.StatusList, error) {
+	// Rows created without a list start out empty
+	if len(encodedList) == 0 {
+		status, err := status.NewStatusListWithBits(bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stored status list: %v", err)
+		}
+		return status, nil
+	}
+
+	status, err := status.Decode(string(encodedList), bits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode status list: %v", err)
+	}
+
+	if allocated == nil {
+		// Lists from before random allocation handed out indexes without recording
+		// them, so treat every index as taken rather than risk reusing one
+		for index := 0; index < status.Len(); index++ {
+			status.Reserve(index)
+		}
+	} else if err := status.DecodeAllocations(string(allocated)); err != nil {
+		return nil, fmt.Errorf("failed to decode allocations: %v", err)
+	}
+
+	return status, nil
+}
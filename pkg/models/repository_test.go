@@ -0,0 +1,420 @@
+package models
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/database"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, NewMemoryRepository())
+	testConcurrentUpdates(t, NewMemoryRepository())
+	testStatusHistory(t, NewMemoryRepository())
+	testStatusListAt(t, NewMemoryRepository())
+	testStatusMeta(t, NewMemoryRepository())
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	testRepository(t, NewSQLRepository(openSQLite(t)))
+	testConcurrentUpdates(t, NewSQLRepository(openSQLite(t)))
+	testStatusHistory(t, NewSQLRepository(openSQLite(t)))
+	testStatusListAt(t, NewSQLRepository(openSQLite(t)))
+	testStatusMeta(t, NewSQLRepository(openSQLite(t)))
+}
+
+func TestSQLiteLegacyStatusMeta(t *testing.T) {
+	db := openSQLite(t)
+	repo := NewSQLRepository(db)
+
+	// A list last written before capacity and used were stored
+	list, _ := status.NewStatusListWithSize(1, 16)
+	encodedList, allocated, err := encodeStatusList(list)
+	if err != nil {
+		t.Fatalf("Error encoding status list: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO statuses (encoded_list, bits, allocated) VALUES ($1, 1, $2)", encodedList, allocated); err != nil {
+		t.Fatalf("Error inserting status: %v", err)
+	}
+
+	meta, err := repo.GetStatusMeta("1")
+	if err != nil {
+		t.Fatalf("Error getting status metadata: %v", err)
+	}
+	if meta.Capacity != 16 || meta.Used != 0 || meta.Sealed || meta.CreatedAt != nil || meta.Purpose != "" {
+		t.Fatalf("Expected metadata decoded from the list, got %+v", meta)
+	}
+
+	// Listing decodes legacy lists too, after the cursor holding SQLite's single connection is closed
+	if _, err := repo.CreateNewStatus(list, "suspension"); err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+	done := make(chan struct{})
+	var metas []StatusMeta
+	go func() {
+		metas, err = repo.ListStatusMeta()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Listing status metadata with a legacy list did not return")
+	}
+	if err != nil {
+		t.Fatalf("Error listing status metadata: %v", err)
+	}
+	if len(metas) != 2 || metas[0].Capacity != 16 || metas[0].Sealed || metas[1].Capacity != 16 || metas[1].Purpose != "suspension" {
+		t.Fatalf("Expected the legacy and the new list, got %+v", metas)
+	}
+}
+
+// openSQLite returns a migrated SQLite database in a temporary file.
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := database.Open("sqlite", filepath.Join(t.TempDir(), "status.db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := database.MigrateUp(db, "sqlite"); err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+	return db
+}
+
+func testRepository(t *testing.T, repo StatusRepository) {
+	list, err := status.NewStatusListWithSize(2, 64)
+	if err != nil {
+		t.Fatalf("Error creating status list: %v", err)
+	}
+	index, err := list.Allocate()
+	if err != nil {
+		t.Fatalf("Error allocating index: %v", err)
+	}
+	list.Set(index, 2)
+
+	statusId, err := repo.CreateNewStatus(list, "")
+	if err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+
+	stored, err := repo.GetStatus(statusId)
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	if stored.Bits() != 2 || stored.Len() != 64 || stored.Used() != 1 {
+		t.Fatalf("Expected 2 bits, 64 entries and 1 used, got %d, %d and %d", stored.Bits(), stored.Len(), stored.Used())
+	}
+	if value, _ := stored.Get(index); value != 2 || !stored.Allocated(index) {
+		t.Fatalf("Expected allocated status 2 at index %d, got %d", index, value)
+	}
+
+	// Changes are only visible once saved
+	stored.Set(index, 1)
+	if again, _ := repo.GetStatus(statusId); mustGet(t, again, index) != 2 {
+		t.Fatalf("Expected unsaved change to stay invisible")
+	}
+	if err := repo.SaveStatus(statusId, stored); err != nil {
+		t.Fatalf("Error saving status: %v", err)
+	}
+	if again, _ := repo.GetStatus(statusId); mustGet(t, again, index) != 1 {
+		t.Fatalf("Expected saved change to be visible")
+	}
+
+	otherId, err := repo.CreateNewStatus(status.NewStatusList(), "")
+	if err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+	statusIds, err := repo.GetAllStatusIds()
+	if err != nil {
+		t.Fatalf("Error getting status ids: %v", err)
+	}
+	if len(statusIds) != 2 || statusIds[0] != statusId || statusIds[1] != otherId {
+		t.Fatalf("Expected [%s %s], got %v", statusId, otherId, statusIds)
+	}
+
+	if err := repo.DeleteStatus(statusId); err != nil {
+		t.Fatalf("Error deleting status: %v", err)
+	}
+	if _, err := repo.GetStatus(statusId); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := repo.SaveStatus(statusId, stored); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound saving a deleted status, got %v", err)
+	}
+	if err := repo.DeleteStatus(statusId); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	// IDs of deleted lists are not reused, not even the most recent one
+	if err := repo.DeleteStatus(otherId); err != nil {
+		t.Fatalf("Error deleting status: %v", err)
+	}
+	newId, err := repo.CreateNewStatus(status.NewStatusList(), "")
+	if err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+	if newId == statusId || newId == otherId {
+		t.Fatalf("Expected a fresh ID, got the deleted %s again", newId)
+	}
+}
+
+// testConcurrentUpdates allocates and revokes entries of one list from many goroutines
+// at once and checks that no update is lost.
+func testConcurrentUpdates(t *testing.T, repo StatusRepository) {
+	const workers = 16
+	const updates = 8
+
+	list, err := status.NewStatusListWithSize(1, 1024)
+	if err != nil {
+		t.Fatalf("Error creating status list: %v", err)
+	}
+	statusId, err := repo.CreateNewStatus(list, "")
+	if err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	indexes := make(chan int, workers*updates)
+	errs := make(chan error, workers*updates)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := 0; u < updates; u++ {
+				var index int
+				err := repo.UpdateStatus(statusId, Audit{Actor: "test"}, func(list *status.StatusList) error {
+					var err error
+					index, err = list.Allocate()
+					return err
+				})
+				if err == nil {
+					err = repo.UpdateStatus(statusId, Audit{Actor: "test"}, func(list *status.StatusList) error {
+						return list.SetStatus(index, true)
+					})
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				indexes <- index
+			}
+		}()
+	}
+	wg.Wait()
+	close(indexes)
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Error updating status: %v", err)
+	}
+
+	stored, err := repo.GetStatus(statusId)
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	if stored.Used() != workers*updates {
+		t.Fatalf("Expected %d allocated indexes, got %d", workers*updates, stored.Used())
+	}
+	seen := make(map[int]bool)
+	for index := range indexes {
+		if seen[index] {
+			t.Fatalf("Index %d was allocated twice", index)
+		}
+		seen[index] = true
+		if mustGet(t, stored, index) != 1 {
+			t.Fatalf("Lost the update of index %d", index)
+		}
+		// Retried attempts must not leave events behind
+		if history, err := repo.StatusHistory(statusId, index); err != nil || len(history) != 1 {
+			t.Fatalf("Expected 1 event for index %d, got %d, %v", index, len(history), err)
+		}
+	}
+}
+
+func testStatusHistory(t *testing.T, repo StatusRepository) {
+	list, err := status.NewStatusListWithSize(2, 64)
+	if err != nil {
+		t.Fatalf("Error creating status list: %v", err)
+	}
+	statusId, err := repo.CreateNewStatus(list, "")
+	if err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+
+	before := time.Now().UTC().Add(-time.Second)
+	updates := []struct {
+		audit Audit
+		value uint8
+	}{
+		{Audit{Actor: "alice", Reason: "suspected"}, 2},
+		{Audit{Actor: "bob", Reason: "key_compromise"}, 1},
+		{Audit{Actor: "bob"}, 1},
+	}
+	for _, update := range updates {
+		value := update.value
+		err := repo.UpdateStatus(statusId, update.audit, func(list *status.StatusList) error {
+			return list.Set(7, value)
+		})
+		if err != nil {
+			t.Fatalf("Error updating status: %v", err)
+		}
+	}
+
+	history, err := repo.StatusHistory(statusId, 7)
+	if err != nil {
+		t.Fatalf("Error getting status history: %v", err)
+	}
+	// The last update changed nothing and is not recorded
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 events, got %+v", history)
+	}
+	first, second := history[0], history[1]
+	if first.StatusId != statusId || first.Index != 7 || first.OldValue != 0 || first.NewValue != 2 || first.Actor != "alice" || first.Reason != "suspected" {
+		t.Fatalf("Unexpected first event: %+v", first)
+	}
+	if second.OldValue != 2 || second.NewValue != 1 || second.Actor != "bob" || second.Reason != "key_compromise" {
+		t.Fatalf("Unexpected second event: %+v", second)
+	}
+	if first.Time.Before(before) || second.Time.Before(first.Time) || second.Time.After(time.Now().Add(time.Second)) {
+		t.Fatalf("Unexpected event times %v and %v", first.Time, second.Time)
+	}
+
+	// The history outlives the list
+	if err := repo.DeleteStatus(statusId); err != nil {
+		t.Fatalf("Error deleting status: %v", err)
+	}
+	if history, err := repo.StatusHistory(statusId, 7); err != nil || len(history) != 2 {
+		t.Fatalf("Expected 2 events after delete, got %d, %v", len(history), err)
+	}
+	if history, err := repo.StatusHistory(statusId, 8); err != nil || len(history) != 0 {
+		t.Fatalf("Expected no events for an untouched index, got %d, %v", len(history), err)
+	}
+}
+
+func testStatusListAt(t *testing.T, repo StatusRepository) {
+	beforeCreation := time.Now().Add(-time.Second)
+	statusId, err := repo.CreateNewStatus(status.NewStatusList(), "")
+	if err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+
+	// Revoke 4711, suspend 42, then reinstate 4711, noting the time after each step
+	steps := []struct {
+		index int
+		value uint8
+	}{{4711, 1}, {42, 1}, {4711, 0}}
+	times := []time.Time{time.Now()}
+	for _, step := range steps {
+		time.Sleep(5 * time.Millisecond)
+		index, value := step.index, step.value
+		err := repo.UpdateStatus(statusId, Audit{Actor: "test"}, func(list *status.StatusList) error {
+			return list.Set(index, value)
+		})
+		if err != nil {
+			t.Fatalf("Error updating status: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		times = append(times, time.Now())
+	}
+
+	expected := [][2]uint8{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+	for i, at := range times {
+		list, err := repo.StatusListAt(statusId, at)
+		if err != nil {
+			t.Fatalf("Error reconstructing status list: %v", err)
+		}
+		if got := [2]uint8{mustGet(t, list, 4711), mustGet(t, list, 42)}; got != expected[i] {
+			t.Fatalf("Expected 4711 and 42 to be %v after step %d, got %v", expected[i], i, got)
+		}
+	}
+
+	// Reconstruction leaves the current list alone
+	if current, _ := repo.GetStatus(statusId); mustGet(t, current, 42) != 1 {
+		t.Fatalf("Expected the current list to be unchanged")
+	}
+
+	if _, err := repo.StatusListAt(statusId, beforeCreation); err != ErrNotYetCreated {
+		t.Fatalf("Expected ErrNotYetCreated before creation, got %v", err)
+	}
+	if _, err := repo.StatusListAt("999", time.Now()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for an unknown list, got %v", err)
+	}
+}
+
+func testStatusMeta(t *testing.T, repo StatusRepository) {
+	list, err := status.NewStatusListWithSize(1, 8)
+	if err != nil {
+		t.Fatalf("Error creating status list: %v", err)
+	}
+	statusId, err := repo.CreateNewStatus(list, "suspension")
+	if err != nil {
+		t.Fatalf("Error creating status: %v", err)
+	}
+
+	allocate := func() {
+		err := repo.UpdateStatus(statusId, Audit{Actor: "test"}, func(list *status.StatusList) error {
+			_, err := list.Allocate()
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Error allocating index: %v", err)
+		}
+	}
+	for i := 0; i < 7; i++ {
+		allocate()
+	}
+
+	meta, err := repo.GetStatusMeta(statusId)
+	if err != nil {
+		t.Fatalf("Error getting status metadata: %v", err)
+	}
+	if meta.StatusId != statusId || meta.Purpose != "suspension" || meta.Bits != 1 || meta.Capacity != 8 || meta.Used != 7 || meta.Sealed || meta.SealedAt != nil {
+		t.Fatalf("Unexpected metadata of an open list: %+v", meta)
+	}
+	if meta.CreatedAt == nil || time.Since(*meta.CreatedAt) > time.Minute {
+		t.Fatalf("Expected a recent creation time, got %v", meta.CreatedAt)
+	}
+
+	// The last allocation seals the list
+	allocate()
+	sealed, err := repo.GetStatusMeta(statusId)
+	if err != nil {
+		t.Fatalf("Error getting status metadata: %v", err)
+	}
+	if sealed.Used != 8 || !sealed.Sealed || sealed.SealedAt == nil || sealed.SealedAt.Before(*meta.CreatedAt) {
+		t.Fatalf("Unexpected metadata of a full list: %+v", sealed)
+	}
+
+	// Later changes keep the time it was sealed
+	time.Sleep(5 * time.Millisecond)
+	err = repo.UpdateStatus(statusId, Audit{Actor: "test"}, func(list *status.StatusList) error {
+		return list.SetStatus(3, true)
+	})
+	if err != nil {
+		t.Fatalf("Error updating status: %v", err)
+	}
+	metas, err := repo.ListStatusMeta()
+	if err != nil {
+		t.Fatalf("Error listing status metadata: %v", err)
+	}
+	if len(metas) != 1 || metas[0].SealedAt == nil || !metas[0].SealedAt.Equal(*sealed.SealedAt) {
+		t.Fatalf("Expected the seal time to be kept, got %+v", metas)
+	}
+
+	if _, err := repo.GetStatusMeta("999"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for an unknown list, got %v", err)
+	}
+}
+
+func mustGet(t *testing.T, list *status.StatusList, index int) uint8 {
+	value, err := list.Get(index)
+	if err != nil {
+		t.Fatalf("Error getting index %d: %v", index, err)
+	}
+	return value
+}
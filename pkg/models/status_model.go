@@ -3,49 +3,206 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"math/rand"
+	"time"
 
-	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/database"
 	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
 )
 
-func GetStatus(statusId string) (*status.StatusList, error) {
-	var encodedList []byte
-	err := database.DB.QueryRow("SELECT encoded_list FROM statuses WHERE id = $1", statusId).Scan(&encodedList)
+// SQLRepository stores status lists in the statuses table of a PostgreSQL or SQLite
+// database. Both understand the $n placeholders and RETURNING clause used here.
+type SQLRepository struct {
+	db *sql.DB
+}
+
+// NewSQLRepository creates a StatusRepository backed by db, as opened by database.Open.
+func NewSQLRepository(db *sql.DB) *SQLRepository {
+	return &SQLRepository{db: db}
+}
+
+// maxUpdateAttempts bounds how often UpdateStatus retries after losing to a concurrent update.
+const maxUpdateAttempts = 20
+
+func (r *SQLRepository) GetStatus(statusId string) (*status.StatusList, error) {
+	status, _, err := r.getStatus(statusId)
+	return status, err
+}
+
+// getStatus returns the list stored under statusId along with its version.
+func (r *SQLRepository) getStatus(statusId string) (*status.StatusList, int64, error) {
+	var encodedList, allocated []byte
+	var bits int
+	var version int64
+	err := r.db.QueryRow("SELECT encoded_list, bits, allocated, version FROM statuses WHERE id = $1", statusId).Scan(&encodedList, &bits, &allocated, &version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("status not found")
+			return nil, 0, ErrNotFound
 		}
-		return nil, fmt.Errorf("failed to query status: %v", err)
+		return nil, 0, fmt.Errorf("failed to query status: %v", err)
 	}
 
-	status := status.NewStatusList()
-	// Decode the status list from the stored encodedList
-	// TODO: Add decoding logic
-	return status, nil
+	status, err := decodeStatusList(encodedList, allocated, bits)
+	return status, version, err
 }
 
-func SaveStatus(statusId string, status *status.StatusList) error {
-	encodedList, err := status.Encode()
+func (r *SQLRepository) SaveStatus(statusId string, status *status.StatusList) error {
+	encodedList, allocated, err := encodeStatusList(status)
 	if err != nil {
-		return fmt.Errorf("failed to encode status list: %v", err)
+		return err
 	}
 
-	_, err = database.DB.Exec("UPDATE statuses SET encoded_list = $1 WHERE id = $2", encodedList, statusId)
+	result, err := r.db.Exec("UPDATE statuses SET encoded_list = $1, allocated = $2, capacity = $3, used = $4, sealed_at = COALESCE(sealed_at, $5), version = version + 1 WHERE id = $6",
+		encodedList, allocated, status.Len(), status.Used(), sealTime(status), statusId)
 	if err != nil {
 		return fmt.Errorf("failed to update status: %v", err)
 	}
 
-	return nil
+	return requireRow(result)
+}
+
+// UpdateStatus reads the list with its version and only writes it back if the version is
+// unchanged, retrying with the newer list otherwise. The events of the change are written
+// in the same transaction.
+func (r *SQLRepository) UpdateStatus(statusId string, audit Audit, fn func(*status.StatusList) error) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			backoff(attempt)
+		}
+
+		before, version, err := r.getStatus(statusId)
+		if err != nil {
+			return err
+		}
+		after := before.Clone()
+		if err := fn(after); err != nil {
+			return err
+		}
+
+		saved, err := r.saveVersion(statusId, version, after, statusEvents(statusId, before, after, audit, time.Now().UTC()))
+		if err != nil {
+			return err
+		}
+		// Not saved means another update bumped the version, or the list was deleted, which the next read reports
+		if saved {
+			return nil
+		}
+	}
+
+	return ErrConflict
+}
+
+// saveVersion writes status and its events if the stored list is still at version.
+func (r *SQLRepository) saveVersion(statusId string, version int64, status *status.StatusList, events []StatusEvent) (bool, error) {
+	encodedList, allocated, err := encodeStatusList(status)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE statuses SET encoded_list = $1, allocated = $2, capacity = $3, used = $4, sealed_at = COALESCE(sealed_at, $5), version = version + 1 WHERE id = $6 AND version = $7",
+		encodedList, allocated, status.Len(), status.Used(), sealTime(status), statusId, version)
+	if err != nil {
+		return false, fmt.Errorf("failed to update status: %v", err)
+	}
+	if err := requireRow(result); err != nil {
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, event := range events {
+		_, err := tx.Exec("INSERT INTO status_events (status_id, status_index, old_value, new_value, actor, reason, changed_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			event.StatusId, event.Index, event.OldValue, event.NewValue, event.Actor, event.Reason, event.Time)
+		if err != nil {
+			return false, fmt.Errorf("failed to record status event: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit status update: %v", err)
+	}
+	return true, nil
+}
+
+func (r *SQLRepository) StatusHistory(statusId string, index int) ([]StatusEvent, error) {
+	rows, err := r.db.Query("SELECT status_id, status_index, old_value, new_value, actor, reason, changed_at FROM status_events WHERE status_id = $1 AND status_index = $2 ORDER BY id", statusId, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status history: %v", err)
+	}
+	defer rows.Close()
+
+	history := []StatusEvent{}
+	for rows.Next() {
+		var event StatusEvent
+		if err := rows.Scan(&event.StatusId, &event.Index, &event.OldValue, &event.NewValue, &event.Actor, &event.Reason, &event.Time); err != nil {
+			return nil, fmt.Errorf("failed to scan status event: %v", err)
+		}
+		event.Time = event.Time.UTC()
+		history = append(history, event)
+	}
+
+	return history, rows.Err()
 }
 
-func CreateNewStatus(status *status.StatusList) (string, error) {
-	encodedList, err := status.Encode()
+// StatusListAt reads the list before the events to undo: a change committed in between is
+// then among the events, and undoing it on a list that does not have it yet is harmless.
+func (r *SQLRepository) StatusListAt(statusId string, at time.Time) (*status.StatusList, error) {
+	list, _, err := r.getStatus(statusId)
 	if err != nil {
-		return "", fmt.Errorf("failed to encode status list: %v", err)
+		return nil, err
+	}
+
+	var createdAt sql.NullTime
+	if err := r.db.QueryRow("SELECT created_at FROM statuses WHERE id = $1", statusId).Scan(&createdAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query status creation time: %v", err)
+	}
+	// Lists from before creation times were recorded are assumed to have always existed
+	if createdAt.Valid && at.Before(createdAt.Time) {
+		return nil, ErrNotYetCreated
+	}
+
+	rows, err := r.db.Query("SELECT status_index, old_value FROM status_events WHERE status_id = $1 AND changed_at > $2 ORDER BY id DESC", statusId, at.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status events: %v", err)
+	}
+	defer rows.Close()
+
+	var later []StatusEvent
+	for rows.Next() {
+		var event StatusEvent
+		if err := rows.Scan(&event.Index, &event.OldValue); err != nil {
+			return nil, fmt.Errorf("failed to scan status event: %v", err)
+		}
+		later = append(later, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query status events: %v", err)
+	}
+
+	if err := revertEvents(list, later); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *SQLRepository) CreateNewStatus(status *status.StatusList, purpose string) (string, error) {
+	encodedList, allocated, err := encodeStatusList(status)
+	if err != nil {
+		return "", err
 	}
 
 	var statusId string
-	err = database.DB.QueryRow("INSERT INTO statuses (encoded_list) VALUES ($1) RETURNING id", encodedList).Scan(&statusId)
+	err = r.db.QueryRow("INSERT INTO statuses (encoded_list, bits, allocated, created_at, purpose, capacity, used, sealed_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		encodedList, status.Bits(), allocated, time.Now().UTC(), purpose, status.Len(), status.Used(), sealTime(status)).Scan(&statusId)
 	if err != nil {
 		return "", fmt.Errorf("failed to insert new status: %v", err)
 	}
@@ -53,14 +210,104 @@ func CreateNewStatus(status *status.StatusList) (string, error) {
 	return statusId, nil
 }
 
-func GetAllStatusIds() ([]string, error) {
-	rows, err := database.DB.Query("SELECT id FROM statuses")
+// metaColumns are the columns scanned by scanMeta.
+const metaColumns = "id, purpose, bits, capacity, used, created_at, sealed_at"
+
+func (r *SQLRepository) GetStatusMeta(statusId string) (*StatusMeta, error) {
+	meta, legacy, err := scanMeta(r.db.QueryRow("SELECT "+metaColumns+" FROM statuses WHERE id = $1", statusId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query status metadata: %v", err)
+	}
+	if legacy {
+		if err := r.decodeMeta(meta); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+func (r *SQLRepository) ListStatusMeta() ([]StatusMeta, error) {
+	rows, err := r.db.Query("SELECT " + metaColumns + " FROM statuses ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status metadata: %v", err)
+	}
+	defer rows.Close()
+
+	metas := []StatusMeta{}
+	var legacy []int
+	for rows.Next() {
+		meta, isLegacy, err := scanMeta(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan status metadata: %v", err)
+		}
+		if isLegacy {
+			legacy = append(legacy, len(metas))
+		}
+		metas = append(metas, *meta)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan status metadata: %v", err)
+	}
+
+	// Legacy lists are decoded once the cursor is closed, as SQLite has a single connection
+	rows.Close()
+	for _, i := range legacy {
+		if err := r.decodeMeta(&metas[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return metas, nil
+}
+
+// scanMeta reads a row of metaColumns. It reports lists last written before capacity and
+// used were stored as legacy, for decodeMeta to fill them in.
+func scanMeta(row interface{ Scan(...interface{}) error }) (*StatusMeta, bool, error) {
+	var meta StatusMeta
+	var capacity, used sql.NullInt64
+	var createdAt, sealedAt sql.NullTime
+	if err := row.Scan(&meta.StatusId, &meta.Purpose, &meta.Bits, &capacity, &used, &createdAt, &sealedAt); err != nil {
+		return nil, false, err
+	}
+
+	if capacity.Valid && used.Valid {
+		meta.Capacity = int(capacity.Int64)
+		meta.Used = int(used.Int64)
+		meta.Sealed = meta.Used >= meta.Capacity
+	}
+	if createdAt.Valid {
+		t := createdAt.Time.UTC()
+		meta.CreatedAt = &t
+	}
+	if sealedAt.Valid {
+		t := sealedAt.Time.UTC()
+		meta.SealedAt = &t
+	}
+
+	return &meta, !capacity.Valid || !used.Valid, nil
+}
+
+// decodeMeta fills in the capacity and usage of a legacy list by decoding the list.
+func (r *SQLRepository) decodeMeta(meta *StatusMeta) error {
+	list, _, err := r.getStatus(meta.StatusId)
+	if err != nil {
+		return err
+	}
+	*meta = newStatusMeta(meta.StatusId, meta.Purpose, list, meta.CreatedAt, meta.SealedAt)
+	return nil
+}
+
+func (r *SQLRepository) GetAllStatusIds() ([]string, error) {
+	rows, err := r.db.Query("SELECT id FROM statuses ORDER BY id")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query status ids: %v", err)
 	}
 	defer rows.Close()
 
-	var statusIds []string
+	statusIds := []string{}
 	for rows.Next() {
 		var id string
 		if err := rows.Scan(&id); err != nil {
@@ -69,5 +316,40 @@ func GetAllStatusIds() ([]string, error) {
 		statusIds = append(statusIds, id)
 	}
 
-	return statusIds, nil
+	return statusIds, rows.Err()
+}
+
+func (r *SQLRepository) DeleteStatus(statusId string) error {
+	result, err := r.db.Exec("DELETE FROM statuses WHERE id = $1", statusId)
+	if err != nil {
+		return fmt.Errorf("failed to delete status: %v", err)
+	}
+
+	return requireRow(result)
+}
+
+// sealTime is the sealed_at to store for list: now once it is full. The statements keep an
+// earlier sealed_at.
+func sealTime(list *status.StatusList) sql.NullTime {
+	return sql.NullTime{Time: time.Now().UTC(), Valid: list.Used() >= list.Len()}
+}
+
+// backoff waits a random, growing time before retry attempt, so contending updates spread out.
+func backoff(attempt int) {
+	if attempt > 8 {
+		attempt = 8
+	}
+	time.Sleep(time.Duration(rand.Int63n(int64(time.Millisecond) << uint(attempt))))
+}
+
+// requireRow returns ErrNotFound when a statement affected no rows.
+func requireRow(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %v", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
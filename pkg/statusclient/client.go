@@ -0,0 +1,269 @@
+// Package statusclient resolves status references (a list URI and an index) against
+// IETF Token Status List endpoints, verifying every token against the issuer's JWKS.
+package statusclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+// Status is the value of a status entry.
+type Status uint8
+
+// Status values defined by the IETF Token Status List draft. Values 3 and up are application specific.
+const (
+	Valid     Status = 0
+	Invalid   Status = 1
+	Suspended Status = 2
+)
+
+func (s Status) String() string {
+	switch s {
+	case Valid:
+		return "VALID"
+	case Invalid:
+		return "INVALID"
+	case Suspended:
+		return "SUSPENDED"
+	}
+	return fmt.Sprintf("APPLICATION_SPECIFIC(0x%02x)", uint8(s))
+}
+
+// JWKSPath is where the key set is published relative to the issuer URL.
+const JWKSPath = "/.well-known/jwks.json"
+
+// maxTokenSize bounds a status list token response.
+const maxTokenSize = 16 << 20
+
+// Client fetches, verifies and caches status list tokens. It is safe for concurrent use.
+type Client struct {
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	keySets map[string]*crypto.RemoteKeySet
+	lists   map[string]cachedList
+
+	now func() time.Time
+}
+
+type cachedList struct {
+	list      *status.StatusList
+	expiresAt time.Time
+}
+
+// New creates a Client. A nil httpClient is replaced by one with a 10 second timeout.
+func New(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{
+		httpClient: httpClient,
+		keySets:    make(map[string]*crypto.RemoteKeySet),
+		lists:      make(map[string]cachedList),
+		now:        time.Now,
+	}
+}
+
+// Status returns the status at index of the list published at uri.
+func (c *Client) Status(ctx context.Context, uri string, index int) (Status, error) {
+	list, err := c.StatusList(ctx, uri)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := list.Get(index)
+	if err != nil {
+		return 0, fmt.Errorf("index %d: %v", index, err)
+	}
+
+	return Status(value), nil
+}
+
+// StatusList returns the verified list published at uri, from cache while its ttl allows.
+func (c *Client) StatusList(ctx context.Context, uri string) (*status.StatusList, error) {
+	c.mu.Lock()
+	cached, ok := c.lists[uri]
+	c.mu.Unlock()
+	if ok && c.now().Before(cached.expiresAt) {
+		return cached.list, nil
+	}
+
+	body, err := c.fetch(ctx, uri, crypto.StatusListContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := c.verify(ctx, uri, body)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := status.DecodeLst(claims.StatusList.Lst, claims.StatusList.Bits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status list: %v", err)
+	}
+
+	// Cache for ttl, but never past the token's expiry
+	if claims.TTL > 0 {
+		expiresAt := c.now().Add(time.Duration(claims.TTL) * time.Second)
+		if claims.ExpiresAt > 0 && time.Unix(claims.ExpiresAt, 0).Before(expiresAt) {
+			expiresAt = time.Unix(claims.ExpiresAt, 0)
+		}
+		c.mu.Lock()
+		c.lists[uri] = cachedList{list: list, expiresAt: expiresAt}
+		c.mu.Unlock()
+	}
+
+	return list, nil
+}
+
+// StatusListURIs returns the status list URIs of the verified status list aggregation
+// published at uri, so all lists of an issuer can be fetched ahead of time. Every URI
+// must share the origin of the aggregation.
+func (c *Client) StatusListURIs(ctx context.Context, uri string) ([]string, error) {
+	body, err := c.fetch(ctx, uri, crypto.AggregationContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := c.issuerKey(ctx, uri, body)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := crypto.ParseStatusListAggregation(body, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == 0 && claims.TTL == 0 {
+		return nil, errors.New("status list aggregation has neither exp nor ttl")
+	}
+
+	for _, listURI := range claims.StatusLists {
+		if err := sameOrigin(claims.Issuer, listURI); err != nil {
+			return nil, err
+		}
+	}
+
+	return claims.StatusLists, nil
+}
+
+func (c *Client) fetch(ctx context.Context, uri, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch status list: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch status list: received non-OK HTTP status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTokenSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status list: %v", err)
+	}
+
+	return body, nil
+}
+
+// verify checks the token's signature against the issuer's JWKS and its claims against uri.
+func (c *Client) verify(ctx context.Context, uri string, body []byte) (*crypto.StatusListClaims, error) {
+	publicKey, err := c.issuerKey(ctx, uri, body)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := crypto.ParseStatusListToken(body, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject != uri {
+		return nil, fmt.Errorf("status list token subject %s does not match %s", claims.Subject, uri)
+	}
+	if claims.ExpiresAt == 0 && claims.TTL == 0 {
+		return nil, errors.New("status list token has neither exp nor ttl")
+	}
+
+	return claims, nil
+}
+
+// issuerKey returns the key named by the token's kid from the JWKS of its issuer, which
+// must share the origin of uri. The token itself is not verified yet.
+func (c *Client) issuerKey(ctx context.Context, uri string, body []byte) (*ecdsa.PublicKey, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(strings.TrimSpace(string(body)), jwt.MapClaims{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse status list token: %v", err)
+	}
+
+	kid, _ := unverified.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("status list token has no kid")
+	}
+
+	issuer, _ := unverified.Claims.(jwt.MapClaims)["iss"].(string)
+	if err := sameOrigin(issuer, uri); err != nil {
+		return nil, err
+	}
+
+	publicKey, err := c.keySet(issuer).Key(ctx, kid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find key %s of %s: %v", kid, issuer, err)
+	}
+	return publicKey, nil
+}
+
+func (c *Client) keySet(issuer string) *crypto.RemoteKeySet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keySet, ok := c.keySets[issuer]
+	if !ok {
+		keySet = crypto.NewRemoteKeySet(strings.TrimSuffix(issuer, "/")+JWKSPath, c.httpClient)
+		c.keySets[issuer] = keySet
+	}
+	return keySet
+}
+
+// sameOrigin requires the issuer to be served from the origin of the list, so a list
+// can only be vouched for by keys its own host publishes.
+func sameOrigin(issuer, uri string) error {
+	if issuer == "" {
+		return errors.New("status list token has no issuer")
+	}
+
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return fmt.Errorf("invalid issuer %q: %v", issuer, err)
+	}
+	listURL, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid status list URI %q: %v", uri, err)
+	}
+
+	if issuerURL.Scheme != listURL.Scheme || issuerURL.Host != listURL.Host {
+		return fmt.Errorf("issuer %s does not serve %s", issuer, uri)
+	}
+
+	return nil
+}
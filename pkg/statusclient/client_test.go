@@ -0,0 +1,168 @@
+package statusclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/crypto"
+	"github.com/korentmaj/go-ecdsa-status-netis-challenge/internal/status"
+)
+
+func TestClient(t *testing.T) {
+	signer, err := crypto.GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+	keys := crypto.NewKeyRegistry(signer, time.Hour)
+
+	list, _ := status.NewStatusListWithSize(2, 16)
+	list.Set(3, uint8(Invalid))
+	list.Set(7, uint8(Suspended))
+	list.Set(9, 3)
+
+	var listRequests int32
+	subject := ""
+	historical := false
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc(JWKSPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=300")
+		json.NewEncoder(w).Encode(keys.JWKS())
+	})
+	mux.HandleFunc("/statuslists/1", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&listRequests, 1)
+		lst, _ := list.EncodeLst()
+		sub := subject
+		if sub == "" {
+			sub = server.URL + "/statuslists/1"
+		}
+		claims := map[string]interface{}{
+			"sub":         sub,
+			"iss":         server.URL,
+			"iat":         time.Now().Unix(),
+			"exp":         time.Now().Add(time.Hour).Unix(),
+			"ttl":         60,
+			"status_list": map[string]interface{}{"bits": 2, "lst": lst},
+		}
+		if historical {
+			claims["historical"] = true
+		}
+		token, err := crypto.SignJWS(keys.Active(), crypto.StatusListJWTType, claims)
+		if err != nil {
+			t.Errorf("Error signing token: %v", err)
+		}
+		w.Header().Set("Content-Type", crypto.StatusListContentType)
+		w.Write([]byte(token))
+	})
+
+	client := New(nil)
+	uri := server.URL + "/statuslists/1"
+	ctx := context.Background()
+
+	for index, want := range map[int]Status{0: Valid, 3: Invalid, 7: Suspended, 9: 3} {
+		got, err := client.Status(ctx, uri, index)
+		if err != nil {
+			t.Fatalf("Error getting status %d: %v", index, err)
+		}
+		if got != want {
+			t.Fatalf("Expected status %d to be %v, got %v", index, want, got)
+		}
+	}
+	if _, err := client.Status(ctx, uri, 16); err == nil {
+		t.Fatalf("Expected index 16 to be out of range")
+	}
+
+	// The list is cached for its ttl
+	if n := atomic.LoadInt32(&listRequests); n != 1 {
+		t.Fatalf("Expected 1 list request, got %d", n)
+	}
+	client.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := client.Status(ctx, uri, 0); err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	if n := atomic.LoadInt32(&listRequests); n != 2 {
+		t.Fatalf("Expected the list to be refetched after its ttl, got %d requests", n)
+	}
+	client.now = time.Now
+
+	// After a rotation the token's kid selects the new key from the JWKS
+	next, _ := crypto.GenerateMemorySigner()
+	if err := keys.Rotate(next); err != nil {
+		t.Fatalf("Error rotating keys: %v", err)
+	}
+	client = New(server.Client())
+	if _, err := client.Status(ctx, uri, 0); err != nil {
+		t.Fatalf("Error getting status after rotation: %v", err)
+	}
+
+	// A token for another list is rejected
+	subject = server.URL + "/statuslists/2"
+	client = New(nil)
+	if _, err := client.Status(ctx, uri, 0); err == nil {
+		t.Fatalf("Expected subject mismatch to be rejected")
+	}
+
+	// A historical token does not tell the current status
+	subject = ""
+	historical = true
+	client = New(nil)
+	if _, err := client.Status(ctx, uri, 0); err == nil {
+		t.Fatalf("Expected historical token to be rejected")
+	}
+}
+
+func TestStatusListURIs(t *testing.T) {
+	signer, err := crypto.GenerateMemorySigner()
+	if err != nil {
+		t.Fatalf("Error generating signer: %v", err)
+	}
+	keys := crypto.NewKeyRegistry(signer, time.Hour)
+
+	var statusLists []string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc(JWKSPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keys.JWKS())
+	})
+	mux.HandleFunc("/statuslists", func(w http.ResponseWriter, r *http.Request) {
+		token, err := crypto.SignJWS(keys.Active(), crypto.AggregationJWTType, map[string]interface{}{
+			"iss":          server.URL,
+			"iat":          time.Now().Unix(),
+			"exp":          time.Now().Add(time.Hour).Unix(),
+			"status_lists": statusLists,
+		})
+		if err != nil {
+			t.Errorf("Error signing aggregation: %v", err)
+		}
+		w.Header().Set("Content-Type", crypto.AggregationContentType)
+		w.Write([]byte(token))
+	})
+
+	client := New(nil)
+	ctx := context.Background()
+	uri := server.URL + "/statuslists"
+
+	statusLists = []string{server.URL + "/statuslists/1", server.URL + "/statuslists/2"}
+	got, err := client.StatusListURIs(ctx, uri)
+	if err != nil {
+		t.Fatalf("Error getting status list URIs: %v", err)
+	}
+	if len(got) != 2 || got[0] != statusLists[0] || got[1] != statusLists[1] {
+		t.Fatalf("Expected %v, got %v", statusLists, got)
+	}
+
+	// Lists of another origin are not vouched for by this issuer
+	statusLists = append(statusLists, "https://elsewhere.test/statuslists/1")
+	if _, err := client.StatusListURIs(ctx, uri); err == nil {
+		t.Fatalf("Expected a list of another origin to be rejected")
+	}
+}
@@ -1,3 +1,4 @@
 #!/bin/bash
+# Creates the database schema, or brings it up to date, with the server's embedded migrations.
 
-psql -U postgres -d your_database -f /path/to/project-root/internal/database/migrations/001_create_status_table.sql
+cd "$(dirname "$0")/.." && go run ./cmd/server "$@" migrate up